@@ -0,0 +1,270 @@
+package dot
+
+import (
+	"github.com/timtadh/combos"
+	"github.com/timtadh/dot/ast"
+)
+
+// Parse the text and convert the resulting tree into a typed *ast.File.
+func ParseAST(text []byte) (*ast.File, error) {
+	n, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return BuildAST(n)
+}
+
+// Convert a "Graphs" tree as returned by Parse into a typed *ast.File.
+func BuildAST(n *combos.Node) (*ast.File, error) {
+	return newASTBuilder().file(n)
+}
+
+// Convert a "Graph" node into a typed *ast.Graph.
+func BuildGraph(n *combos.Node) (*ast.Graph, error) {
+	return newASTBuilder().graph(n)
+}
+
+// Convert a single statement node, such as those delivered to
+// Callbacks.Stmt, into a typed ast.Stmt.
+func BuildStmt(n *combos.Node) (ast.Stmt, error) {
+	return newASTBuilder().stmt(n)
+}
+
+type astBuilder struct{}
+
+func newASTBuilder() *astBuilder {
+	return &astBuilder{}
+}
+
+func (b *astBuilder) file(n *combos.Node) (*ast.File, error) {
+	if n.Label != "Graphs" {
+		return nil, n.Error("Expected Graphs got %v", n)
+	}
+	f := &ast.File{Span: span(n)}
+	for _, kid := range n.Children {
+		switch kid.Label {
+		case "Graph":
+			g, err := b.graph(kid)
+			if err != nil {
+				return nil, err
+			}
+			f.Decls = append(f.Decls, g)
+		case "COMMENT":
+			f.Decls = append(f.Decls, b.comment(kid))
+		default:
+			return nil, kid.Error("Unexpected node %v", kid)
+		}
+	}
+	return f, nil
+}
+
+func (b *astBuilder) graph(n *combos.Node) (*ast.Graph, error) {
+	if n.Label != "Graph" || len(n.Children) != 3 {
+		return nil, n.Error("Expected Graph got %v", n)
+	}
+	graphType := n.Get(0)
+	stmts, err := b.stmts(n.Get(2))
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Graph{
+		Span:     span(n),
+		Strict:   len(graphType.Children) > 0 && graphType.Get(0).Label == "STRICT",
+		Directed: graphType.Label == "DIGRAPH",
+		ID:       b.id(n.Get(1)),
+		Stmts:    stmts,
+	}, nil
+}
+
+func (b *astBuilder) stmt(n *combos.Node) (ast.Stmt, error) {
+	switch n.Label {
+	case "Node":
+		attrs, err := b.attrs(n.Get(1))
+		if err != nil {
+			return nil, err
+		}
+		id, err := b.nodeID(n.Get(0))
+		if err != nil {
+			return nil, err
+		}
+		return &ast.NodeStmt{Span: span(n), Node: id, Attrs: attrs}, nil
+	case "Edge":
+		from, err := b.vertex(n.Get(0))
+		if err != nil {
+			return nil, err
+		}
+		to, err := b.vertex(n.Get(1))
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := b.attrs(n.Get(2))
+		if err != nil {
+			return nil, err
+		}
+		return &ast.EdgeStmt{Span: span(n), Vertices: []ast.Vertex{from, to}, Attrs: attrs}, nil
+	case "Attr":
+		return &ast.Assign{
+			Span:  span(n),
+			Name:  b.id(n.Get(0)),
+			Value: b.id(n.Get(1)),
+		}, nil
+	case "GraphAttrs", "NodeAttrs", "EdgeAttrs":
+		attrs, err := b.attrs(n)
+		if err != nil {
+			return nil, err
+		}
+		kind := ast.GraphAttrs
+		switch n.Label {
+		case "NodeAttrs":
+			kind = ast.NodeAttrs
+		case "EdgeAttrs":
+			kind = ast.EdgeAttrs
+		}
+		return &ast.AttrStmt{Span: span(n), Kind: kind, Attrs: attrs}, nil
+	case "SubGraph":
+		return b.subgraph(n)
+	case "COMMENT":
+		return b.comment(n), nil
+	default:
+		return nil, n.Error("Unexpected node %v", n)
+	}
+}
+
+func (b *astBuilder) stmts(n *combos.Node) ([]ast.Stmt, error) {
+	stmts := make([]ast.Stmt, 0, len(n.Children))
+	var prev *combos.Node
+	for _, kid := range n.Children {
+		if chained(prev, kid) {
+			// extend the chain of the previous statement by one vertex
+			e := stmts[len(stmts)-1].(*ast.EdgeStmt)
+			to, err := b.vertex(kid.Get(1))
+			if err != nil {
+				return nil, err
+			}
+			e.Vertices = append(e.Vertices, to)
+			end := span(kid)
+			e.EndOffset, e.EndLine, e.EndColumn = end.EndOffset, end.EndLine, end.EndColumn
+			prev = kid
+			continue
+		}
+		stmt, err := b.stmt(kid)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+		prev = kid
+	}
+	return stmts, nil
+}
+
+// Whether the Edge node n continues the chain of the Edge node prev. The
+// edges of a chain share their attributes and the vertex between them, see
+// unwrapMultiple.
+func chained(prev, n *combos.Node) bool {
+	if prev == nil || prev.Label != "Edge" || n.Label != "Edge" {
+		return false
+	}
+	if len(prev.Children) != 3 || len(n.Children) != 3 {
+		return false
+	}
+	return prev.Get(2) == n.Get(2) && prev.Get(1) == n.Get(0)
+}
+
+func (b *astBuilder) subgraph(n *combos.Node) (*ast.Subgraph, error) {
+	stmts, err := b.stmts(n.Get(1))
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Subgraph{Span: span(n), ID: b.id(n.Get(0)), Stmts: stmts}, nil
+}
+
+func (b *astBuilder) vertex(n *combos.Node) (ast.Vertex, error) {
+	if n.Label == "SubGraph" {
+		return b.subgraph(n)
+	}
+	return b.nodeID(n)
+}
+
+// An ID node with an optional Port kid
+func (b *astBuilder) nodeID(n *combos.Node) (*ast.NodeID, error) {
+	if n.Label != "ID" {
+		return nil, n.Error("Expected ID got %v", n)
+	}
+	// the location of the ID node is that of the ID alone
+	id := &ast.NodeID{Span: locationSpan(extent(n)), ID: b.id(n)}
+	if len(n.Children) > 0 {
+		port := n.Get(0)
+		if port.Label != "Port" {
+			return nil, port.Error("Unexpected node %v", port)
+		}
+		id.Port = &ast.Port{Span: span(port), ID: b.id(port.Get(0))}
+		if len(port.Children) > 1 {
+			id.Port.Compass = b.id(port.Get(1))
+		}
+	}
+	return id, nil
+}
+
+func (b *astBuilder) attrs(n *combos.Node) ([]*ast.Attr, error) {
+	attrs := make([]*ast.Attr, 0, len(n.Children))
+	for _, kid := range n.Children {
+		if kid.Label != "Attr" {
+			return nil, kid.Error("Expected Attr got %v", kid)
+		}
+		attrs = append(attrs, &ast.Attr{
+			Span:  span(kid),
+			Name:  b.id(kid.Get(0)),
+			Value: b.id(kid.Get(1)),
+		})
+	}
+	return attrs, nil
+}
+
+// The value of an ID node
+func (b *astBuilder) id(n *combos.Node) *ast.ID {
+	id := &ast.ID{Span: span(n)}
+	if v, ok := n.Value.(ID); ok {
+		id.Kind = v.Kind
+		id.Value = v.Value
+		id.Raw = v.Raw
+		id.Anonymous = v.Anonymous
+	} else {
+		id.Value = idValue(n)
+	}
+	return id
+}
+
+func (b *astBuilder) comment(n *combos.Node) *ast.Comment {
+	text, _ := n.Value.(string)
+	return &ast.Comment{Span: span(n), Text: text}
+}
+
+// The location of a span, the inverse of span
+func location(s ast.Span) *combos.Location {
+	return &combos.Location{
+		StartTC:     s.StartOffset,
+		EndTC:       s.EndOffset,
+		StartLine:   s.StartLine,
+		StartColumn: s.StartColumn,
+		EndLine:     s.EndLine,
+		EndColumn:   s.EndColumn,
+	}
+}
+
+func span(n *combos.Node) ast.Span {
+	return locationSpan(n.Location())
+}
+
+func locationSpan(l *combos.Location) ast.Span {
+	if l == nil {
+		return ast.Span{}
+	}
+	return ast.Span{
+		StartOffset: l.StartTC,
+		EndOffset:   l.EndTC,
+		StartLine:   l.StartLine,
+		StartColumn: l.StartColumn,
+		EndLine:     l.EndLine,
+		EndColumn:   l.EndColumn,
+	}
+}
@@ -0,0 +1,218 @@
+// Package ast defines a typed syntax tree for the graphviz dot language.
+//
+// The tree mirrors the untyped *combos.Node tree produced by dot.Parse but
+// replaces its string labels and positional children with concrete types.
+// Use dot.ParseAST to obtain one.
+package ast
+
+// Node is implemented by every element of the tree.
+type Node interface {
+	Location() Span
+}
+
+// Span is the region of the source text a node was parsed from. Offsets are
+// byte offsets, lines and columns are 1 based. A node the parser made up,
+// such as the ID of an anonymous graph, has an empty span where it would
+// have been written.
+type Span struct {
+	StartOffset int
+	EndOffset   int
+	StartLine   int
+	StartColumn int
+	EndLine     int
+	EndColumn   int
+}
+
+func (s Span) Location() Span {
+	return s
+}
+
+// Decl is a top level element of a file: a *Graph or a *Comment.
+type Decl interface {
+	Node
+	declNode()
+}
+
+// Stmt is a statement in the body of a graph or subgraph: one of *NodeStmt,
+// *EdgeStmt, *AttrStmt, *Assign, *Subgraph or *Comment.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Vertex is an endpoint of an edge: a *NodeID or a *Subgraph.
+type Vertex interface {
+	Node
+	vertexNode()
+}
+
+// File is a sequence of graphs and the comments between them.
+type File struct {
+	Span
+	Decls []Decl
+}
+
+// Graph is a top level `[strict] (graph | digraph) [ID] { ... }`.
+type Graph struct {
+	Span
+	Trivia
+	Strict   bool
+	Directed bool
+	ID       *ID
+	Stmts    []Stmt
+}
+
+// NodeStmt declares (or re-declares) a node with optional attributes.
+type NodeStmt struct {
+	Span
+	Trivia
+	Node  *NodeID
+	Attrs []*Attr
+}
+
+// EdgeStmt is a chain of edges such as `a -> b -> c`, one edge between
+// each pair of consecutive Vertices. The edges share the attributes of the
+// chain. A single edge has two Vertices.
+type EdgeStmt struct {
+	Span
+	Trivia
+	Vertices []Vertex
+	Attrs    []*Attr
+}
+
+// AttrKind identifies the target of an AttrStmt.
+type AttrKind int
+
+const (
+	GraphAttrs AttrKind = iota
+	NodeAttrs
+	EdgeAttrs
+)
+
+func (k AttrKind) String() string {
+	switch k {
+	case GraphAttrs:
+		return "graph"
+	case NodeAttrs:
+		return "node"
+	case EdgeAttrs:
+		return "edge"
+	}
+	return "unknown"
+}
+
+// AttrStmt sets default attributes: `(graph | node | edge) [ ... ]`.
+type AttrStmt struct {
+	Span
+	Trivia
+	Kind  AttrKind
+	Attrs []*Attr
+}
+
+// Assign is a bare graph attribute statement: `ID = ID`.
+type Assign struct {
+	Span
+	Trivia
+	Name  *ID
+	Value *ID
+}
+
+// Subgraph is `[subgraph [ID]] { ... }`. Anonymous subgraphs are given a
+// generated ID by the parser, see ID.Anonymous.
+type Subgraph struct {
+	Span
+	Trivia
+	ID    *ID
+	Stmts []Stmt
+}
+
+// NodeID names a node and optionally one of its ports.
+type NodeID struct {
+	Span
+	ID   *ID
+	Port *Port
+}
+
+// Port is `:ID [:compass_pt]`. When only one ID is given it is stored in ID,
+// even if it is a compass point.
+type Port struct {
+	Span
+	ID      *ID
+	Compass *ID
+}
+
+// Attr is a single `name = value` pair inside an attribute list.
+type Attr struct {
+	Span
+	Name  *ID
+	Value *ID
+}
+
+// Comment is a `//` or `/* */` comment including its delimiters.
+type Comment struct {
+	Span
+	Text string
+}
+
+// Trivia are the comments documenting a graph or a statement, see
+// AttachComments. The Leading comments are on the lines directly before it
+// and the Trailing comments follow it on the line it ends on.
+type Trivia struct {
+	Leading  []*Comment
+	Trailing []*Comment
+}
+
+func (t *Trivia) Comments() *Trivia {
+	return t
+}
+
+// IDKind is the form an ID was written in. Graphviz treats a quoted string
+// and an HTML string with the same text differently, `label="<b>"` is not
+// the same as `label=<<b>>`.
+type IDKind int
+
+const (
+	Bare    IDKind = iota // a name such as a_1
+	Numeral               // a number such as 1.5
+	Quoted                // a double quoted string
+	HTML                  // an HTML string delimited by < and >
+)
+
+func (k IDKind) String() string {
+	switch k {
+	case Bare:
+		return "bare"
+	case Numeral:
+		return "numeral"
+	case Quoted:
+		return "quoted"
+	case HTML:
+		return "html"
+	}
+	return "unknown"
+}
+
+// ID is an identifier. Value has the quotes or angle brackets removed and
+// the escaped quotes and line continuations of quoted strings decoded. Raw
+// is the ID as it was written, it is empty for generated IDs. The IDs of
+// anonymous graphs and subgraphs are generated and marked Anonymous.
+type ID struct {
+	Span
+	Kind      IDKind
+	Value     string
+	Raw       string
+	Anonymous bool
+}
+
+func (*Graph) declNode()   {}
+func (*Comment) declNode() {}
+
+func (*NodeStmt) stmtNode() {}
+func (*EdgeStmt) stmtNode() {}
+func (*AttrStmt) stmtNode() {}
+func (*Assign) stmtNode()   {}
+func (*Subgraph) stmtNode() {}
+func (*Comment) stmtNode()  {}
+
+func (*NodeID) vertexNode()   {}
+func (*Subgraph) vertexNode() {}
@@ -0,0 +1,97 @@
+package ast
+
+import (
+	"strings"
+)
+
+// A node which comments can be attached to
+type commented interface {
+	Node
+	Comments() *Trivia
+}
+
+// AttachComments moves the comments of f into the Trivia of the graphs and
+// statements they document. A comment starting on the line a graph or
+// statement ends on is one of its Trailing comments. The comments on the
+// lines directly before a graph or statement, without a blank line between
+// them, are its Leading comments. The other comments, such as those at the
+// end of a body, are left where they are.
+func AttachComments(f *File) {
+	decls := make([]Node, 0, len(f.Decls))
+	for _, d := range f.Decls {
+		if g, ok := d.(*Graph); ok {
+			g.Stmts = attachStmts(g.Stmts)
+		}
+		decls = append(decls, d)
+	}
+	f.Decls = f.Decls[:0]
+	for _, n := range attach(decls) {
+		f.Decls = append(f.Decls, n.(Decl))
+	}
+}
+
+func attachStmts(stmts []Stmt) []Stmt {
+	nodes := make([]Node, 0, len(stmts))
+	for _, s := range stmts {
+		switch x := s.(type) {
+		case *Subgraph:
+			x.Stmts = attachStmts(x.Stmts)
+		case *EdgeStmt:
+			for _, v := range x.Vertices {
+				if sg, ok := v.(*Subgraph); ok {
+					sg.Stmts = attachStmts(sg.Stmts)
+				}
+			}
+		}
+		nodes = append(nodes, s)
+	}
+	attached := make([]Stmt, 0, len(nodes))
+	for _, n := range attach(nodes) {
+		attached = append(attached, n.(Stmt))
+	}
+	return attached
+}
+
+// Attach the comments of a sequence of nodes returning the nodes which
+// remain.
+func attach(nodes []Node) []Node {
+	kept := make([]Node, 0, len(nodes))
+	var pending []*Comment // comments which may lead the next node
+	var prev commented     // the node before pending
+	flush := func() {
+		for _, c := range pending {
+			kept = append(kept, c)
+		}
+		pending = nil
+	}
+	for _, n := range nodes {
+		if c, ok := n.(*Comment); ok {
+			if prev != nil && len(pending) == 0 && c.StartLine == prev.Location().EndLine {
+				t := prev.Comments()
+				t.Trailing = append(t.Trailing, c)
+				continue
+			}
+			if len(pending) > 0 && lastLine(pending[len(pending)-1])+1 < c.StartLine {
+				flush()
+			}
+			pending = append(pending, c)
+			continue
+		}
+		prev, _ = n.(commented)
+		if len(pending) > 0 && prev != nil && lastLine(pending[len(pending)-1])+1 >= n.Location().StartLine {
+			t := prev.Comments()
+			t.Leading = append(t.Leading, pending...)
+			pending = nil
+		}
+		flush()
+		kept = append(kept, n)
+	}
+	flush()
+	return kept
+}
+
+// The line a comment ends on. The newline ending a // comment is part of
+// its text but not of its line.
+func lastLine(c *Comment) int {
+	return c.StartLine + strings.Count(strings.TrimRight(c.Text, "\r\n"), "\n")
+}
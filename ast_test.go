@@ -0,0 +1,177 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"strings"
+)
+
+import (
+	"github.com/timtadh/dot/ast"
+)
+
+func TestASTGraph(x *testing.T) {
+	t := (*test.T)(x)
+	f, err := ParseAST([]byte(`// leading
+	strict digraph G {
+		rankdir=LR
+		node [shape=box]
+		a:p:n [label="A"]
+		a -> {b c} [weight=2]
+		subgraph cluster_x { d }
+		/* done */
+	}`))
+	t.AssertNil(err)
+	t.Assert(len(f.Decls) == 2, "expected 2 decls got %v", len(f.Decls))
+	_, ok := f.Decls[0].(*ast.Comment)
+	t.Assert(ok, "expected a comment got %T", f.Decls[0])
+	g, ok := f.Decls[1].(*ast.Graph)
+	t.Assert(ok, "expected a graph got %T", f.Decls[1])
+	t.Assert(g.Strict && g.Directed, "expected a strict digraph %v", g)
+	t.Assert(g.ID.Value == "G", "expected G got %v", g.ID.Value)
+	t.Assert(len(g.Stmts) == 6, "expected 6 stmts got %v", len(g.Stmts))
+
+	assign := g.Stmts[0].(*ast.Assign)
+	t.Assert(assign.Name.Value == "rankdir" && assign.Value.Value == "LR", "bad assign %v", assign)
+
+	defaults := g.Stmts[1].(*ast.AttrStmt)
+	t.Assert(defaults.Kind == ast.NodeAttrs, "expected node attrs got %v", defaults.Kind)
+	t.Assert(len(defaults.Attrs) == 1 && defaults.Attrs[0].Value.Value == "box", "bad attrs %v", defaults.Attrs)
+
+	node := g.Stmts[2].(*ast.NodeStmt)
+	t.Assert(node.Node.ID.Value == "a", "expected a got %v", node.Node.ID.Value)
+	t.Assert(node.Node.Port != nil, "expected a port")
+	t.Assert(node.Node.Port.ID.Value == "p", "expected p got %v", node.Node.Port.ID.Value)
+	t.Assert(node.Node.Port.Compass.Value == "n", "expected n got %v", node.Node.Port.Compass.Value)
+	t.Assert(len(node.Attrs) == 1 && node.Attrs[0].Name.Value == "label", "bad attrs %v", node.Attrs)
+
+	edge := g.Stmts[3].(*ast.EdgeStmt)
+	t.Assert(len(edge.Vertices) == 2, "expected 2 vertices got %v", len(edge.Vertices))
+	from := edge.Vertices[0].(*ast.NodeID)
+	t.Assert(from.ID.Value == "a", "expected a got %v", from.ID.Value)
+	to := edge.Vertices[1].(*ast.Subgraph)
+	t.Assert(len(to.Stmts) == 2, "expected 2 stmts got %v", len(to.Stmts))
+	t.Assert(len(edge.Attrs) == 1, "expected 1 attr got %v", len(edge.Attrs))
+
+	sg := g.Stmts[4].(*ast.Subgraph)
+	t.Assert(sg.ID.Value == "cluster_x", "expected cluster_x got %v", sg.ID.Value)
+
+	_, ok = g.Stmts[5].(*ast.Comment)
+	t.Assert(ok, "expected a comment got %T", g.Stmts[5])
+}
+
+func TestASTAnonymousGraphs(x *testing.T) {
+	t := (*test.T)(x)
+	f, err := ParseAST([]byte(`graph {} strict graph {}`))
+	t.AssertNil(err)
+	t.Assert(len(f.Decls) == 2, "expected 2 decls got %v", len(f.Decls))
+	for _, d := range f.Decls {
+		g := d.(*ast.Graph)
+		t.Assert(!g.Directed, "expected an undirected graph")
+		t.Assert(g.ID.Value != "", "expected a generated name")
+		t.Assert(g.ID.Anonymous, "expected an anonymous graph")
+	}
+}
+
+func TestASTEdgeChain(x *testing.T) {
+	t := (*test.T)(x)
+	text := []byte(`digraph { a -> b -> c [color=red]; c -> d }`)
+	f, err := ParseAST(text)
+	t.AssertNil(err)
+	g := f.Decls[0].(*ast.Graph)
+	t.Assert(len(g.Stmts) == 2, "expected 2 edge stmts got %v", len(g.Stmts))
+	chain := g.Stmts[0].(*ast.EdgeStmt)
+	t.Assert(len(chain.Attrs) == 1, "expected 1 attr got %v", chain.Attrs)
+	names := make([]string, 0, len(chain.Vertices))
+	for _, v := range chain.Vertices {
+		names = append(names, v.(*ast.NodeID).ID.Value)
+	}
+	assertSameEvents(t, []string{"a", "b", "c"}, names)
+	spanned := string(text[chain.StartOffset:chain.EndOffset])
+	t.Assert(spanned == "a -> b -> c [color=red]", "expected the chain to span its text got %q", spanned)
+	edge := g.Stmts[1].(*ast.EdgeStmt)
+	t.Assert(len(edge.Vertices) == 2, "expected 2 vertices got %v", len(edge.Vertices))
+}
+
+// A subgraph between two edges of a chain is a single vertex of the chain
+func TestASTEdgeChainSubgraph(x *testing.T) {
+	t := (*test.T)(x)
+	f, err := ParseAST([]byte(`digraph { a -> {b c} -> d }`))
+	t.AssertNil(err)
+	g := f.Decls[0].(*ast.Graph)
+	t.Assert(len(g.Stmts) == 1, "expected 1 stmt got %v", len(g.Stmts))
+	chain := g.Stmts[0].(*ast.EdgeStmt)
+	t.Assert(len(chain.Vertices) == 3, "expected 3 vertices got %v", len(chain.Vertices))
+	sg := chain.Vertices[1].(*ast.Subgraph)
+	t.Assert(len(sg.Stmts) == 2, "expected 2 stmts got %v", len(sg.Stmts))
+}
+
+func TestASTIDKinds(x *testing.T) {
+	t := (*test.T)(x)
+	f, err := ParseAST([]byte(`digraph { a [label="<b>", html=<<b>>, w=1] }`))
+	t.AssertNil(err)
+	node := f.Decls[0].(*ast.Graph).Stmts[0].(*ast.NodeStmt)
+	t.Assert(node.Node.ID.Kind == ast.Bare, "expected bare got %v", node.Node.ID.Kind)
+	expected := []ast.IDKind{ast.Quoted, ast.HTML, ast.Numeral}
+	for i, attr := range node.Attrs {
+		t.Assert(attr.Value.Kind == expected[i], "expected %v got %v", expected[i], attr.Value.Kind)
+		t.Assert(attr.Name.Kind == ast.Bare, "expected bare got %v", attr.Name.Kind)
+	}
+	t.Assert(node.Attrs[0].Value.Value == node.Attrs[1].Value.Value, "expected the same text")
+}
+
+const commentedText = `// about g
+digraph g {
+	// the defaults
+	// for every node
+	node [shape=box] // boxes
+
+	// detached
+
+	a -> b /* edge */
+	subgraph s {
+		// c
+		c
+	} // end of s
+	// at the end
+}
+`
+
+func commentTexts(comments []*ast.Comment) []string {
+	texts := make([]string, 0, len(comments))
+	for _, c := range comments {
+		texts = append(texts, strings.TrimRight(c.Text, "\n"))
+	}
+	return texts
+}
+
+func TestAttachComments(x *testing.T) {
+	t := (*test.T)(x)
+	f, err := ParseAST([]byte(commentedText))
+	t.AssertNil(err)
+	ast.AttachComments(f)
+	t.Assert(len(f.Decls) == 1, "expected 1 decl got %v", len(f.Decls))
+	g := f.Decls[0].(*ast.Graph)
+	assertSameEvents(t, []string{"// about g"}, commentTexts(g.Leading))
+	t.Assert(len(g.Stmts) == 5, "expected 5 stmts got %v", len(g.Stmts))
+
+	node := g.Stmts[0].(*ast.AttrStmt)
+	assertSameEvents(t, []string{"// the defaults", "// for every node"}, commentTexts(node.Leading))
+	assertSameEvents(t, []string{"// boxes"}, commentTexts(node.Trailing))
+
+	detached := g.Stmts[1].(*ast.Comment)
+	t.Assert(detached.Text == "// detached\n", "expected the detached comment got %q", detached.Text)
+
+	edge := g.Stmts[2].(*ast.EdgeStmt)
+	t.Assert(len(edge.Leading) == 0, "expected no leading comments got %v", edge.Leading)
+	assertSameEvents(t, []string{"/* edge */"}, commentTexts(edge.Trailing))
+
+	sg := g.Stmts[3].(*ast.Subgraph)
+	assertSameEvents(t, []string{"// end of s"}, commentTexts(sg.Trailing))
+	t.Assert(len(sg.Stmts) == 1, "expected 1 stmt got %v", len(sg.Stmts))
+	assertSameEvents(t, []string{"// c"}, commentTexts(sg.Stmts[0].(*ast.NodeStmt).Leading))
+
+	_, ok := g.Stmts[4].(*ast.Comment)
+	t.Assert(ok, "expected the last comment to stay in the body got %T", g.Stmts[4])
+}
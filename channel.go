@@ -0,0 +1,103 @@
+package dot
+
+import (
+	"context"
+	"io"
+)
+
+import (
+	"github.com/timtadh/combos"
+)
+
+type EventKind int
+
+const (
+	EnterEvent EventKind = iota // the start of a graph or subgraph
+	StmtEvent                   // a statement
+	ExitEvent                   // the end of a graph or subgraph
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EnterEvent:
+		return "enter"
+	case StmtEvent:
+		return "stmt"
+	case ExitEvent:
+		return "exit"
+	}
+	return "unknown"
+}
+
+// An Event sent by StreamParseChan, one for each call the parser makes to
+// its Callbacks. Name is "Graph" or "SubGraph" for EnterEvent and ExitEvent.
+// Node is nil for an ExitEvent.
+type Event struct {
+	Kind EventKind
+	Name string
+	Node *combos.Node
+}
+
+// Parse the graphs read from r sending an Event on the returned channel for
+// each, see DotParser.StreamParseChan.
+func StreamParseChan(ctx context.Context, r io.Reader) (<-chan *Event, <-chan error) {
+	return NewDotParser(nil).StreamParseChan(ctx, r)
+}
+
+// Parse the graphs read from r as ParseReader does, sending the events of
+// the parse on the returned channel rather than delivering them to the
+// Callbacks of the parser. The parse waits for each event to be received.
+// Once the parse ends the events channel is closed and its error, if any,
+// is sent on the error channel which is then closed. Cancelling ctx stops
+// the parse at its next event, it fails with the error of ctx.
+//
+// The parser must not be used again until the events channel is closed.
+func (d *DotParser) StreamParseChan(ctx context.Context, r io.Reader) (<-chan *Event, <-chan error) {
+	events := make(chan *Event)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		call := d.Callbacks
+		d.Callbacks = &chanCallbacks{ctx: ctx, events: events}
+		err := d.ParseReader(r)
+		d.Callbacks = call
+		close(events)
+		if e, ok := err.(*CallbackError); ok && e.Err == ctx.Err() {
+			err = e.Err
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return events, errs
+}
+
+// Sends the calls of the parser as Events
+type chanCallbacks struct {
+	ctx    context.Context
+	events chan<- *Event
+}
+
+func (c *chanCallbacks) send(e *Event) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case c.events <- e:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func (c *chanCallbacks) Enter(name string, n *combos.Node) error {
+	return c.send(&Event{Kind: EnterEvent, Name: name, Node: n})
+}
+
+func (c *chanCallbacks) Stmt(n *combos.Node) error {
+	return c.send(&Event{Kind: StmtEvent, Node: n})
+}
+
+func (c *chanCallbacks) Exit(name string) error {
+	return c.send(&Event{Kind: ExitEvent, Name: name})
+}
@@ -0,0 +1,55 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func eventString(e *Event) string {
+	if e.Kind == StmtEvent {
+		return fmt.Sprintf("stmt %v", e.Node.Label)
+	}
+	return fmt.Sprintf("%v %v", e.Kind, e.Name)
+}
+
+func TestStreamParseChan(x *testing.T) {
+	t := (*test.T)(x)
+	expected := &recordCallbacks{}
+	t.AssertNil(StreamParse([]byte(readerText), expected))
+	events, errs := StreamParseChan(context.Background(), strings.NewReader(readerText))
+	var got []string
+	for e := range events {
+		got = append(got, eventString(e))
+	}
+	t.AssertNil(<-errs)
+	assertSameEvents(t, expected.events, got)
+}
+
+func TestStreamParseChanError(x *testing.T) {
+	t := (*test.T)(x)
+	events, errs := StreamParseChan(context.Background(), strings.NewReader(`digraph { a; b -> }`))
+	var got []string
+	for e := range events {
+		got = append(got, eventString(e))
+	}
+	assertSameEvents(t, []string{"enter Graph", "stmt Node"}, got)
+	_, ok := (<-errs).(*SyntaxError)
+	t.Assert(ok, "expected a SyntaxError")
+}
+
+func TestStreamParseChanCancel(x *testing.T) {
+	t := (*test.T)(x)
+	ctx, cancel := context.WithCancel(context.Background())
+	events, errs := StreamParseChan(ctx, strings.NewReader(readerText))
+	e := <-events
+	t.Assert(e.Kind == EnterEvent && e.Name == "Graph", "expected to enter a graph got %v", eventString(e))
+	cancel()
+	err := <-errs
+	t.Assert(err == context.Canceled, "expected the parse to be canceled got %v", err)
+	_, open := <-events
+	t.Assert(!open, "expected the events to be closed")
+}
@@ -0,0 +1,260 @@
+// Command dotfmt formats graphviz dot files.
+//
+// Usage:
+//
+//	dotfmt [flags] [path ...]
+//
+// Each statement is put on a line of its own, bodies are indented with a tab
+// for each level of nesting, attribute lists are separated by commas and IDs
+// are only quoted when they must be. Comments are kept with the graphs and
+// statements they document. Without a path the standard input is formatted
+// to the standard output. The files given are formatted in place, as are
+// the .dot and .gv files in the directories given.
+//
+// The flags are:
+//
+//	-l
+//		List the files whose formatting differs from dotfmt's rather than
+//		formatting them.
+//	-d
+//		Print the diffs of the files whose formatting differs from dotfmt's
+//		rather than formatting them. The diffs are computed by the diff
+//		command, which must be on the PATH.
+//
+// Files are rewritten by renaming a formatted copy over them, so a failed
+// write leaves the original in place.
+//
+// Files with lines of C preprocessor output, which start with `#`, are not
+// formatted as the parser discards those lines.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	"github.com/timtadh/dot"
+	"github.com/timtadh/dot/ast"
+)
+
+type options struct {
+	list bool // list the files which differ
+	diff bool // print the diffs of the files which differ
+}
+
+func main() {
+	var opts options
+	flag.BoolVar(&opts.list, "l", false, "list files whose formatting differs from dotfmt's")
+	flag.BoolVar(&opts.diff, "d", false, "display diffs instead of rewriting files")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: dotfmt [flags] [path ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if opts.diff {
+		if _, err := exec.LookPath("diff"); err != nil {
+			fmt.Fprintf(os.Stderr, "dotfmt: -d requires the diff command: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if flag.NArg() == 0 {
+		if err := opts.process("<standard input>", os.Stdin, os.Stdout, true); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+	failed := false
+	for _, path := range flag.Args() {
+		if err := opts.walk(path, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+}
+
+// Format the file at path or the dot files under it
+func (o *options) walk(path string, out io.Writer) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return o.processFile(path, out)
+	}
+	failed := false
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && isDotFile(info) {
+			err = o.processFile(path, out)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+		return nil
+	})
+	if err == nil && failed {
+		err = fmt.Errorf("%v: not every file could be formatted", path)
+	}
+	return err
+}
+
+func isDotFile(info os.FileInfo) bool {
+	name := info.Name()
+	ext := filepath.Ext(name)
+	return !info.IsDir() && !strings.HasPrefix(name, ".") && (ext == ".dot" || ext == ".gv")
+}
+
+func (o *options) processFile(path string, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return o.process(path, f, out, false)
+}
+
+// Format the dot read from in. The formatted text is written to out when
+// stdin is set, otherwise it replaces the file at filename unless the files
+// which differ are only to be listed or diffed.
+func (o *options) process(filename string, in io.Reader, out io.Writer, stdin bool) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	res, err := format(src)
+	if err != nil {
+		if e, ok := err.(*dot.SyntaxError); ok && e.File == "" {
+			e.File = filename
+			return e
+		}
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+	if !bytes.Equal(src, res) {
+		if o.list {
+			fmt.Fprintln(out, filename)
+		}
+		if o.diff {
+			d, err := diff(src, res, filename)
+			if err != nil {
+				return fmt.Errorf("computing diff: %v", err)
+			}
+			fmt.Fprintf(out, "diff -u %s %s\n", filepath.ToSlash(filename+".orig"), filepath.ToSlash(filename))
+			out.Write(d)
+		}
+		if !o.list && !o.diff && !stdin {
+			if err := replaceFile(filename, res); err != nil {
+				return err
+			}
+		}
+	}
+	if !o.list && !o.diff && stdin {
+		_, err = out.Write(res)
+	}
+	return err
+}
+
+// Replace the contents of the file at filename with data. The data is
+// written to a file in the same directory which is renamed over the
+// original, so the original is never left partly written.
+func replaceFile(filename string, data []byte) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".dotfmt")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(info.Mode().Perm())
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// The canonical formatting of src
+func format(src []byte) ([]byte, error) {
+	if line := preprocessed(src); line > 0 {
+		return nil, fmt.Errorf("line %d starts with #, preprocessor lines would be lost", line)
+	}
+	f, err := dot.ParseAST(src)
+	if err != nil {
+		return nil, err
+	}
+	ast.AttachComments(f)
+	var buf bytes.Buffer
+	if err := dot.PrintAST(&buf, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The first line of src which is C preprocessor output, 0 when there is
+// none. The lexer discards these lines so they cannot be printed back.
+func preprocessed(src []byte) int {
+	for i, line := range bytes.Split(src, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("#")) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// The unified diff from a to b, run by the diff command
+func diff(a, b []byte, filename string) ([]byte, error) {
+	f1, err := writeTemp(a)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTemp(b)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+	name := filepath.ToSlash(filename)
+	data, err := exec.Command("diff", "-u", "-L", name+".orig", "-L", name, f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a status of 1 when the files differ
+		return data, nil
+	}
+	return data, err
+}
+
+func writeTemp(data []byte) (string, error) {
+	f, err := ioutil.TempFile("", "dotfmt")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const messy = `/* the graph */
+digraph   g{a->b[x=1;y="2"] // an edge
+subgraph s{c;d}
+  "e" [label="a b"]}
+`
+
+const formatted = `/* the graph */
+digraph g {
+	a -> b [x=1, y=2]; // an edge
+	subgraph s {
+		c;
+		d;
+	}
+	e [label="a b"];
+}
+`
+
+func TestFormat(x *testing.T) {
+	t := (*test.T)(x)
+	res, err := format([]byte(messy))
+	t.AssertNil(err)
+	t.Assert(string(res) == formatted, "expected\n%s\ngot\n%s", formatted, res)
+	res, err = format(res)
+	t.AssertNil(err)
+	t.Assert(string(res) == formatted, "expected formatting to be stable got\n%s", res)
+}
+
+// The chains are printed as written, each subgraph and attribute list once
+func TestFormatChains(x *testing.T) {
+	t := (*test.T)(x)
+	cases := []struct {
+		src, expected string
+	}{
+		{
+			"digraph { a -> {b /* x */ c} -> d [color=red] }",
+			"digraph {\n\ta -> subgraph {\n\t\tb; /* x */\n\t\tc;\n\t} -> d [color=red];\n}\n",
+		},
+		{
+			"digraph {\na -> {\n// first\nb\n} -> c -> {d} [w=1] // chain\n}\n",
+			"digraph {\n\ta -> subgraph {\n\t\t// first\n\t\tb;\n\t} -> c -> subgraph {\n\t\td;\n\t} [w=1]; // chain\n}\n",
+		},
+		{
+			"graph { a -- b -- c; c -- d }",
+			"graph {\n\ta -- b -- c;\n\tc -- d;\n}\n",
+		},
+	}
+	for _, c := range cases {
+		res, err := format([]byte(c.src))
+		t.AssertNil(err)
+		t.Assert(string(res) == c.expected, "expected\n%s\ngot\n%s", c.expected, res)
+		res, err = format(res)
+		t.AssertNil(err)
+		t.Assert(string(res) == c.expected, "expected formatting to be stable got\n%s", res)
+	}
+}
+
+func TestFormatPreprocessed(x *testing.T) {
+	t := (*test.T)(x)
+	_, err := format([]byte("# 1 \"a.dot\"\ndigraph {}\n"))
+	t.Assert(err != nil, "expected the preprocessor line to be refused")
+}
+
+func TestFormatFiles(x *testing.T) {
+	t := (*test.T)(x)
+	dir, err := ioutil.TempDir("", "dotfmt")
+	t.AssertNil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "g.dot")
+	t.AssertNil(ioutil.WriteFile(path, []byte(messy), 0644))
+	t.AssertNil(ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte(messy), 0644))
+
+	var out bytes.Buffer
+	t.AssertNil((&options{list: true}).walk(dir, &out))
+	t.Assert(out.String() == path+"\n", "expected %v listed got %q", path, out.String())
+	src, err := ioutil.ReadFile(path)
+	t.AssertNil(err)
+	t.Assert(string(src) == messy, "expected -l to leave the file alone got\n%s", src)
+
+	out.Reset()
+	t.AssertNil((&options{}).walk(dir, &out))
+	t.Assert(out.Len() == 0, "expected no output got %q", out.String())
+	src, err = ioutil.ReadFile(path)
+	t.AssertNil(err)
+	t.Assert(string(src) == formatted, "expected\n%s\ngot\n%s", formatted, src)
+	src, err = ioutil.ReadFile(filepath.Join(dir, "notes.txt"))
+	t.AssertNil(err)
+	t.Assert(string(src) == messy, "expected only dot files to be formatted")
+
+	t.AssertNil((&options{list: true}).walk(dir, &out))
+	t.Assert(out.Len() == 0, "expected nothing listed got %q", out.String())
+}
+
+func TestReplaceFile(x *testing.T) {
+	t := (*test.T)(x)
+	dir, err := ioutil.TempDir("", "dotfmt")
+	t.AssertNil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "g.dot")
+	t.AssertNil(ioutil.WriteFile(path, []byte(messy), 0600))
+	t.AssertNil(replaceFile(path, []byte(formatted)))
+	src, err := ioutil.ReadFile(path)
+	t.AssertNil(err)
+	t.Assert(string(src) == formatted, "expected\n%s\ngot\n%s", formatted, src)
+	info, err := os.Stat(path)
+	t.AssertNil(err)
+	t.Assert(info.Mode().Perm() == 0600, "expected the mode to be kept got %v", info.Mode())
+	names, err := ioutil.ReadDir(dir)
+	t.AssertNil(err)
+	t.Assert(len(names) == 1, "expected no temporary files left got %v", len(names))
+}
+
+func TestProcessStdin(x *testing.T) {
+	t := (*test.T)(x)
+	var out bytes.Buffer
+	err := (&options{}).process("<standard input>", bytes.NewReader([]byte(messy)), &out, true)
+	t.AssertNil(err)
+	t.Assert(out.String() == formatted, "expected\n%s\ngot\n%s", formatted, out.String())
+}
@@ -0,0 +1,114 @@
+package dot
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+)
+
+import (
+	"github.com/timtadh/combos"
+)
+
+// A Parser parses with a fixed set of options. Unlike a DotParser, which
+// holds the state of a single parse, a Parser may be shared by any number
+// of goroutines: each call uses a DotParser of its own.
+type Parser struct {
+	Options ParseOptions
+	// The most files ParseMany parses at once, runtime.GOMAXPROCS(0) when
+	// zero.
+	Workers int
+}
+
+func NewParser(opts ParseOptions) *Parser {
+	return &Parser{Options: opts}
+}
+
+func (p *Parser) dotParser(call Callbacks) *DotParser {
+	d := NewDotParser(call)
+	d.Options = p.Options
+	return d
+}
+
+// Parse the text returning the tree and the Diagnostics of the parse. With
+// Options.Recover set the text is parsed as by DotParser.ParseRecover.
+func (p *Parser) Parse(text []byte) (*combos.Node, []*Diagnostic, error) {
+	d := p.dotParser(nil)
+	if p.Options.Recover {
+		return d.ParseRecover(text)
+	}
+	n, err := d.Parse(text)
+	return n, d.Diagnostics, err
+}
+
+// Parse the text delivering its statements to call, see StreamParse.
+func (p *Parser) StreamParse(text []byte, call Callbacks) ([]*Diagnostic, error) {
+	d := p.dotParser(call)
+	_, err := d.Parse(text)
+	return d.Diagnostics, err
+}
+
+// Parse the graphs read from r delivering their statements to call, see
+// DotParser.ParseReader.
+func (p *Parser) ParseReader(r io.Reader, call Callbacks) ([]*Diagnostic, error) {
+	d := p.dotParser(call)
+	err := d.ParseReader(r)
+	return d.Diagnostics, err
+}
+
+// Parse the graphs read from r sending their events on the returned
+// channel, see DotParser.StreamParseChan.
+func (p *Parser) StreamParseChan(ctx context.Context, r io.Reader) (<-chan *Event, <-chan error) {
+	return p.dotParser(nil).StreamParseChan(ctx, r)
+}
+
+// The outcome of parsing one of the files given to ParseMany
+type Result struct {
+	Path        string
+	Tree        *combos.Node
+	Diagnostics []*Diagnostic
+	Err         error // an error reading or parsing the file
+}
+
+// Parse the files at paths in parallel, at most Workers at a time. The
+// results are in the order of paths.
+func (p *Parser) ParseMany(paths []string) []*Result {
+	results := make([]*Result, len(paths))
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = p.parseFile(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func (p *Parser) parseFile(path string) *Result {
+	r := &Result{Path: path}
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Tree, r.Diagnostics, r.Err = p.Parse(text)
+	return r
+}
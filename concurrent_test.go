@@ -0,0 +1,125 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	. "github.com/timtadh/combos"
+)
+
+var concurrentTexts = []string{
+	`digraph { a -> b [w=1]; subgraph { c } }`,
+	`graph g { a -- {b c} -- d; subgraph { e } }`,
+	`digraph { x -- y; y -- z }`,
+	`strict digraph { node [shape=box]; x -> y }`,
+}
+
+// Call f runs times from each of workers goroutines, failing t on the first
+// error of each goroutine. f is given the sum of the goroutine and the run so
+// the goroutines start at different inputs.
+func runConcurrent(t *test.T, workers, runs int, f func(run int) error) {
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			for i := 0; i < runs; i++ {
+				if err := f(w + i); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(w)
+	}
+	for w := 0; w < workers; w++ {
+		t.AssertNil(<-errs)
+	}
+}
+
+func TestParserShared(x *testing.T) {
+	t := (*test.T)(x)
+	p := NewParser(ParseOptions{Lenient: true})
+	expected := make([]*Node, len(concurrentTexts))
+	warnings := make([]int, len(concurrentTexts))
+	for i, text := range concurrentTexts {
+		n, diags, err := p.Parse([]byte(text))
+		t.AssertNil(err)
+		expected[i] = n
+		warnings[i] = len(diags)
+	}
+	t.Assert(warnings[2] == 2, "expected 2 warnings got %v", warnings[2])
+	runConcurrent(t, 16, 25, func(run int) error {
+		j := run % len(concurrentTexts)
+		n, diags, err := p.Parse([]byte(concurrentTexts[j]))
+		if err == nil && !n.Equal(expected[j]) {
+			err = fmt.Errorf("expected %v got %v", expected[j], n)
+		} else if err == nil && len(diags) != warnings[j] {
+			err = fmt.Errorf("expected %v warnings got %v", warnings[j], diags)
+		}
+		return err
+	})
+}
+
+func TestRuneColumnsConcurrent(x *testing.T) {
+	t := (*test.T)(x)
+	// the lines are long so a column counted wrong is far off
+	texts := make([]string, 4)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("digraph {\n%v -> b%v\n}", strings.Repeat("日", 100*(i+1)), i)
+	}
+	runConcurrent(t, 16, 25, func(run int) error {
+		j := run % len(texts)
+		n, err := Parse([]byte(texts[j]))
+		if err != nil {
+			return err
+		}
+		// the end of the edge is after the arrow
+		l := n.Get(0).Get(2).Get(0).Get(1).Location()
+		if col := 100*(j+1) + 5; l.StartColumn != col {
+			return fmt.Errorf("%v: expected column %v got %v", j, col, l.StartColumn)
+		}
+		return nil
+	})
+}
+
+func TestParseMany(x *testing.T) {
+	t := (*test.T)(x)
+	dir, err := ioutil.TempDir("", "dot")
+	t.AssertNil(err)
+	defer os.RemoveAll(dir)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.dot", i))
+		text := concurrentTexts[i%len(concurrentTexts)]
+		if i == 7 {
+			text = `digraph { a -> }`
+		}
+		t.AssertNil(ioutil.WriteFile(path, []byte(text), 0644))
+		paths = append(paths, path)
+	}
+	paths = append(paths, filepath.Join(dir, "missing.dot"))
+	p := &Parser{Options: ParseOptions{Lenient: true}, Workers: 3}
+	results := p.ParseMany(paths)
+	t.Assert(len(results) == len(paths), "expected %v results got %v", len(paths), len(results))
+	for i, r := range results {
+		t.Assert(r.Path == paths[i], "result %v is for %v not %v", i, r.Path, paths[i])
+		switch i {
+		case 7:
+			_, ok := r.Err.(*SyntaxError)
+			t.Assert(ok, "expected a SyntaxError got %v", r.Err)
+		case len(paths) - 1:
+			t.Assert(os.IsNotExist(r.Err), "expected a missing file got %v", r.Err)
+		default:
+			t.AssertNil(r.Err)
+			t.Assert(len(r.Tree.Children) == 1, "expected a graph got %v", r.Tree)
+		}
+	}
+	t.Assert(len(p.ParseMany(nil)) == 0, "expected no results")
+}
@@ -0,0 +1,55 @@
+package dot
+
+import (
+	"fmt"
+)
+
+import (
+	"github.com/timtadh/combos"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "unknown"
+}
+
+// A Diagnostic is a problem found in the input which did not stop the parse.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Location *combos.Location
+	// The file the problem is in when the parser honors line markers, in
+	// which case the lines of Location are lines of File.
+	File string
+}
+
+func (d *Diagnostic) String() string {
+	prefix := ""
+	if d.File != "" {
+		prefix = d.File + ":"
+	}
+	if d.Location == nil {
+		return fmt.Sprintf("%v%v: %v", prefix, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%v%d:%d: %v: %v",
+		prefix, d.Location.StartLine, d.Location.StartColumn, d.Severity, d.Message)
+}
+
+// Diagnostics with the same message at the same offset are the same, at is
+// -1 when there is no location
+type diagKey struct {
+	message string
+	at      int
+}
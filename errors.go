@@ -0,0 +1,347 @@
+package dot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+import (
+	"github.com/timtadh/combos"
+	lex "github.com/timtadh/lexmachine"
+)
+
+// A SyntaxError is returned when the input is not valid dot. It points at
+// the token the parser failed on and lists the tokens it would have
+// accepted there.
+type SyntaxError struct {
+	// The file named by the line markers of the input when the parser
+	// honors them, see ParseOptions.LineMarkers. Line and EndLine are then
+	// lines of File.
+	File      string
+	Line      int
+	Column    int // in runes
+	EndLine   int
+	EndColumn int
+	Offset    int    // the byte offset of the token in the input
+	Token     string // the offending token, empty at the end of the input
+	// The names of the tokens which would have been accepted in place of
+	// Token, eg. ID, "{" or "subgraph".
+	Expected []string
+	Message  string
+	Err      *combos.ParseError // the error reported by the grammar
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line == 0 {
+		return e.describe()
+	}
+	return fmt.Sprintf("%v: %v", e.position(), e.describe())
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SyntaxError) position() string {
+	if e.File != "" {
+		return fmt.Sprintf("%v:%d:%d", e.File, e.Line, e.Column)
+	}
+	return fmt.Sprintf("%d:%d", e.Line, e.Column)
+}
+
+// The error without its position
+func (e *SyntaxError) describe() string {
+	msg := e.Message
+	if e.Token != "" {
+		msg = fmt.Sprintf("%v at %q", msg, e.Token)
+	} else if e.Line != 0 {
+		msg += " at end of input"
+	}
+	if len(e.Expected) > 0 {
+		msg += ", expected " + orList(e.Expected)
+	}
+	return msg
+}
+
+// Render the error followed by the line of src it is on with the token
+// underlined, src is the input which failed to parse:
+//
+//	g.dot:3:7: Unexpected token at ";", expected ID, "subgraph" or "{"
+//	   3 |   c -> ;
+//	     |        ^
+func (e *SyntaxError) Format(src []byte) string {
+	if e.Line == 0 || e.Offset > len(src) {
+		return e.Error()
+	}
+	start := bytes.LastIndexByte(src[:e.Offset], '\n') + 1
+	end := bytes.IndexByte(src[e.Offset:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += e.Offset
+	}
+	line := strings.TrimRight(string(src[start:end]), "\r")
+	// keep the tabs before the token so the caret lines up with it
+	pad := []rune(string(src[start:e.Offset]))
+	for i, r := range pad {
+		if r != '\t' {
+			pad[i] = ' '
+		}
+	}
+	width := utf8.RuneCountInString(strings.SplitN(e.Token, "\n", 2)[0])
+	if width < 1 {
+		width = 1
+	}
+	gutter := fmt.Sprintf("%4d", e.Line)
+	return fmt.Sprintf("%v\n%v | %v\n%v | %v%v",
+		e.Error(),
+		gutter, line,
+		strings.Repeat(" ", len(gutter)), string(pad), strings.Repeat("^", width))
+}
+
+// A CallbackError is returned when one of the Callbacks fails, it wraps the
+// error returned by the callback.
+type CallbackError struct {
+	Callback string // Enter, Stmt or Exit
+	Name     string // the name given to Enter or Exit
+	Line     int    // of the node given to Enter or Stmt, 0 for Exit
+	Column   int
+	Err      error
+}
+
+func (e *CallbackError) Error() string {
+	call := e.Callback
+	if e.Name != "" {
+		call += " " + e.Name
+	}
+	if e.Line == 0 {
+		return fmt.Sprintf("%v callback failed: %v", call, e.Err)
+	}
+	return fmt.Sprintf("%d:%d: %v callback failed: %v", e.Line, e.Column, call, e.Err)
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.Err
+}
+
+// Records the first error returned by the callbacks as a CallbackError.
+type failedCallbacks struct {
+	Callbacks
+	err *CallbackError
+}
+
+func (f *failedCallbacks) Enter(name string, n *combos.Node) error {
+	return f.fail("Enter", name, n, f.Callbacks.Enter(name, n))
+}
+
+func (f *failedCallbacks) Stmt(n *combos.Node) error {
+	return f.fail("Stmt", "", n, f.Callbacks.Stmt(n))
+}
+
+func (f *failedCallbacks) Exit(name string) error {
+	return f.fail("Exit", name, nil, f.Callbacks.Exit(name))
+}
+
+func (f *failedCallbacks) fail(callback, name string, n *combos.Node, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &CallbackError{Callback: callback, Name: name, Err: err}
+	if n != nil {
+		if l := n.Location(); l != nil {
+			e.Line, e.Column = l.StartLine, l.StartColumn
+		}
+	}
+	if f.err == nil {
+		f.err = e
+	}
+	return e
+}
+
+// Build a SyntaxError from the error of parsing text. offset maps an offset
+// of the reported locations to an index of text. The position is that of
+// the parsed text, see locate.
+func (d *DotParser) syntaxError(err *combos.ParseError, text []byte, offset func(int) int) *SyntaxError {
+	e := &SyntaxError{Message: err.Error(), Err: err}
+	best, at := furthest(err)
+	if best == nil {
+		return e
+	}
+	e.Message = best.Reason
+	e.Offset = at.StartTC
+	e.Line, e.Column = at.StartLine, at.StartColumn
+	e.EndLine, e.EndColumn = at.EndLine, at.EndColumn
+	i := offset(at.StartTC)
+	if i >= 0 && i < len(text) {
+		j := offset(at.EndTC)
+		if j <= i {
+			_, n := utf8.DecodeRune(text[i:])
+			j = i + n
+		} else if j > len(text) {
+			j = len(text)
+		}
+		e.Token = string(text[i:j])
+		e.Expected = d.expectedTokens(text, i)
+	} else if i >= len(text) {
+		e.Expected = d.expectedTokens(text, len(text))
+	}
+	return e
+}
+
+// The error of a chain located furthest into the input, the error furthest
+// in is the one most likely to be the mistake the author made.
+func furthest(err *combos.ParseError) (*combos.ParseError, *combos.Location) {
+	var best *combos.ParseError
+	var bestAt *combos.Location
+	var walk func(e *combos.ParseError)
+	walk = func(e *combos.ParseError) {
+		if e.At != nil {
+			if l := e.At.Location(); l != nil && (bestAt == nil || l.StartTC > bestAt.StartTC) {
+				best, bestAt = e, l
+			}
+		}
+		for _, c := range e.Chained {
+			walk(c)
+		}
+	}
+	walk(err)
+	return best, bestAt
+}
+
+// The tokens the grammar accepts at index at of text. Each kind of token is
+// tried in turn followed by `= =`, which can never be parsed, and is
+// accepted if the parse fails further in than the token. Only the statement
+// at is in is probed, in a graph of its own, so the probes cost the same
+// however far into the input the error is.
+func (d *DotParser) expectedTokens(text []byte, at int) []string {
+	prefix, start := probeContext(text[:at])
+	g := getGrammar()
+	defer putGrammar(g)
+	lexer := getLexer()
+	defer putLexer(lexer)
+	var expected []string
+	for _, name := range Tokens {
+		if name == "COMMENT" {
+			continue
+		}
+		if ctx := d.Options.Context; ctx != nil && ctx.Err() != nil {
+			break
+		}
+		sample := strings.ToLower(name)
+		if name == "ID" {
+			sample = `"x"`
+		}
+		probe := make([]byte, 0, len(prefix)+at-start+len(sample)+6)
+		probe = append(probe, prefix...)
+		probe = append(probe, text[start:at]...)
+		probe = append(probe, ' ')
+		begin := len(probe)
+		probe = append(probe, sample...)
+		probe = append(probe, " = ="...)
+		s, err := lexer.Scanner(probe)
+		if err != nil {
+			continue
+		}
+		_, perr := g.Parse(s, &DotParser{Options: d.Options})
+		if perr == nil {
+			expected = append(expected, tokenName(name))
+		} else if _, l := furthest(perr); l != nil && l.StartTC > begin {
+			expected = append(expected, tokenName(name))
+		}
+	}
+	return expected
+}
+
+// Where the probes of the tokens expected at the end of text begin. In the
+// body of a graph they begin at the statement text ends in, of the
+// innermost body, wrapped in prefix to parse it as a statement of a graph of
+// the same kind. Otherwise they begin at the start of the last graph.
+func probeContext(text []byte) (prefix string, start int) {
+	lexer := getLexer()
+	defer putLexer(lexer)
+	scan, err := lexer.Scanner(text)
+	if err != nil {
+		return "", 0
+	}
+	// the statement being read in each body entered, innermost last
+	type body struct {
+		stmt  []*lex.Token
+		start int
+	}
+	var bodies []*body
+	var brackets []string
+	kind := "graph"
+	header := false // whether the header of a graph is being read
+	for tok, err, eof := scan.Next(); !eof; tok, err, eof = scan.Next() {
+		if err != nil {
+			return "", 0
+		}
+		t := tok.(*lex.Token)
+		name := Tokens[t.Type]
+		if len(bodies) == 0 {
+			switch name {
+			case "STRICT", "GRAPH", "DIGRAPH":
+				if !header {
+					header, start = true, t.TC
+				}
+				if name != "STRICT" {
+					kind = strings.ToLower(name)
+				}
+			case "{":
+				header = false
+				bodies = append(bodies, &body{})
+			}
+			brackets = nest(brackets, name)
+			continue
+		}
+		b := bodies[len(bodies)-1]
+		// not inside of an attribute list
+		inBody := brackets[len(brackets)-1] == "{"
+		if inBody && len(b.stmt) > 0 && startsStmt(b.stmt, name) {
+			b.stmt = nil
+		}
+		if len(b.stmt) == 0 {
+			b.start = t.TC
+		}
+		brackets = nest(brackets, name)
+		b.stmt = append(b.stmt, t)
+		switch {
+		case name == "{":
+			bodies = append(bodies, &body{})
+		case name == "}":
+			// closes any unclosed `[` along with the body
+			bodies = bodies[:len(bodies)-1]
+			if len(bodies) > 0 {
+				parent := bodies[len(bodies)-1]
+				parent.stmt = append(parent.stmt, t)
+			}
+		case name == ";" && inBody:
+			b.stmt = nil
+		}
+	}
+	if len(bodies) == 0 {
+		return "", start
+	}
+	b := bodies[len(bodies)-1]
+	if len(b.stmt) == 0 {
+		return kind + " _ {", len(text)
+	}
+	return kind + " _ {", b.start
+}
+
+// How a token is named in messages, literals and keywords are quoted.
+func tokenName(name string) string {
+	if name == "ID" {
+		return name
+	}
+	return `"` + strings.ToLower(name) + `"`
+}
+
+func orList(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
@@ -0,0 +1,109 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const badText = "digraph {\n\ta -> b\n\tc -> ;\n}\n"
+
+func assertSyntaxError(t *test.T, err error) *SyntaxError {
+	e, ok := err.(*SyntaxError)
+	t.Assert(ok, "expected a SyntaxError got %T %v", err, err)
+	t.Assert(e.Line == 3 && e.Column == 7, "expected 3:7 got %v:%v", e.Line, e.Column)
+	t.Assert(e.Offset == 24, "expected offset 24 got %v", e.Offset)
+	t.Assert(e.Token == ";", "expected the token ; got %q", e.Token)
+	for _, name := range []string{"ID", `"{"`, `"subgraph"`} {
+		found := false
+		for _, x := range e.Expected {
+			found = found || x == name
+		}
+		t.Assert(found, "expected %v in %v", name, e.Expected)
+	}
+	return e
+}
+
+func TestSyntaxError(x *testing.T) {
+	t := (*test.T)(x)
+	_, err := Parse([]byte(badText))
+	e := assertSyntaxError(t, err)
+	t.Log(e)
+	formatted := e.Format([]byte(badText))
+	t.Log("\n" + formatted)
+	lines := strings.Split(formatted, "\n")
+	t.Assert(len(lines) == 3, "expected 3 lines got %q", formatted)
+	t.Assert(lines[0] == e.Error(), "expected the error first got %q", lines[0])
+	t.Assert(lines[1] == "   3 | \tc -> ;", "expected the source line got %q", lines[1])
+	t.Assert(lines[2] == "     | \t     ^", "expected a caret under ; got %q", lines[2])
+}
+
+func TestSyntaxErrorReader(x *testing.T) {
+	t := (*test.T)(x)
+	err := StreamParseReader(bytes.NewReader([]byte(badText)), &recordCallbacks{})
+	assertSyntaxError(t, err)
+}
+
+func TestSyntaxErrorUnderline(x *testing.T) {
+	t := (*test.T)(x)
+	src := []byte("graph { a -> b }")
+	_, err := Parse(src)
+	e, ok := err.(*SyntaxError)
+	t.Assert(ok, "expected a SyntaxError got %T %v", err, err)
+	t.Assert(e.Token == "->", "expected the token -> got %q", e.Token)
+	formatted := e.Format(src)
+	t.Assert(strings.HasSuffix(formatted, "\n     |           ^^"), "expected -> to be underlined got\n%v", formatted)
+}
+
+func TestExpectedTokens(x *testing.T) {
+	t := (*test.T)(x)
+	long := "digraph {\n" + strings.Repeat("\ta -> b [w=1]\n", 20000) + "\tc -> ;\n}"
+	for _, c := range []struct {
+		text     string
+		expected []string
+		not      []string
+	}{
+		{`digraph { x; subgraph s { a [color=red] b -> } }`, []string{"ID", `"{"`, `"subgraph"`}, []string{`"]"`, `"="`}},
+		{`digraph { subgraph { a [color=red; } }`, []string{"ID"}, []string{`"subgraph"`}},
+		{`graph { a -- b } digraph x y {}`, []string{`"{"`}, []string{"ID"}},
+		{`graph { {a b} -- ; }`, []string{"ID", `"{"`}, []string{`"->"`}},
+		{long, []string{"ID", `"{"`, `"subgraph"`}, nil},
+	} {
+		_, err := Parse([]byte(c.text))
+		e, ok := err.(*SyntaxError)
+		t.Assert(ok, "expected a SyntaxError got %T %v", err, err)
+		has := make(map[string]bool)
+		for _, x := range e.Expected {
+			has[x] = true
+		}
+		for _, name := range c.expected {
+			t.Assert(has[name], "%.40q: expected %v in %v", c.text, name, e.Expected)
+		}
+		for _, name := range c.not {
+			t.Assert(!has[name], "%.40q: expected no %v in %v", c.text, name, e.Expected)
+		}
+	}
+}
+
+func TestCallbackError(x *testing.T) {
+	t := (*test.T)(x)
+	for _, parse := range []func(call Callbacks) error{
+		func(call Callbacks) error {
+			return StreamParse([]byte(`digraph { a; b }`), call)
+		},
+		func(call Callbacks) error {
+			return StreamParseReader(bytes.NewReader([]byte(`digraph { a; b }`)), call)
+		},
+	} {
+		err := parse(&failingCallbacks{})
+		e, ok := err.(*CallbackError)
+		t.Assert(ok, "expected a CallbackError got %T %v", err, err)
+		t.Assert(e.Callback == "Stmt", "expected the Stmt callback got %v", e.Callback)
+		t.Assert(e.Err.Error() == "stop", "expected the error of the callback got %v", e.Err)
+		t.Assert(e.Line == 1 && e.Column == 11, "expected 1:11 got %v:%v", e.Line, e.Column)
+		t.Log(fmt.Sprint(e))
+	}
+}
@@ -0,0 +1,74 @@
+package dot
+
+import (
+	"github.com/timtadh/combos"
+)
+
+// Callbacks which want the statements of a graph by kind. When the
+// Callbacks of a parser are EventCallbacks each statement is delivered to
+// the method for its kind rather than to Stmt, which only receives the
+// statements which are subgraphs, and the end of a subgraph is delivered to
+// ExitSubgraph rather than to Exit.
+type EventCallbacks interface {
+	Callbacks
+	OnNode(n *combos.Node) error         // a Node statement: `a [...]`
+	OnEdge(n *combos.Node) error         // an Edge statement: `a -> b [...]`
+	OnNodeDefaults(n *combos.Node) error // a NodeAttrs statement: `node [...]`
+	OnEdgeDefaults(n *combos.Node) error // an EdgeAttrs statement: `edge [...]`
+	// A GraphAttrs statement, `graph [...]`, or an Attr statement, `a=b`.
+	OnGraphAttr(n *combos.Node) error
+	// A COMMENT within the body of a graph
+	OnComment(n *combos.Node) error
+	// The end of a subgraph, n is the SubGraph node given to Enter. Its
+	// statements are only kept when the parser keeps the tree, see
+	// ParseRecover.
+	ExitSubgraph(n *combos.Node) error
+}
+
+// The Callbacks delivering to call. When call is EventCallbacks the
+// statements are dispatched to its methods.
+func events(call Callbacks) Callbacks {
+	if e, ok := call.(EventCallbacks); ok {
+		return &eventDispatch{EventCallbacks: e}
+	}
+	return call
+}
+
+type eventDispatch struct {
+	EventCallbacks
+	subgraphs []*combos.Node // those entered but not exited
+}
+
+func (e *eventDispatch) Enter(name string, n *combos.Node) error {
+	if name == "SubGraph" {
+		e.subgraphs = append(e.subgraphs, n)
+	}
+	return e.EventCallbacks.Enter(name, n)
+}
+
+func (e *eventDispatch) Stmt(n *combos.Node) error {
+	switch n.Label {
+	case "Node":
+		return e.OnNode(n)
+	case "Edge":
+		return e.OnEdge(n)
+	case "NodeAttrs":
+		return e.OnNodeDefaults(n)
+	case "EdgeAttrs":
+		return e.OnEdgeDefaults(n)
+	case "GraphAttrs", "Attr":
+		return e.OnGraphAttr(n)
+	case "COMMENT":
+		return e.OnComment(n)
+	}
+	return e.EventCallbacks.Stmt(n)
+}
+
+func (e *eventDispatch) Exit(name string) error {
+	if name != "SubGraph" || len(e.subgraphs) == 0 {
+		return e.EventCallbacks.Exit(name)
+	}
+	n := e.subgraphs[len(e.subgraphs)-1]
+	e.subgraphs = e.subgraphs[:len(e.subgraphs)-1]
+	return e.ExitSubgraph(n)
+}
@@ -0,0 +1,87 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"fmt"
+	"strings"
+)
+
+import (
+	. "github.com/timtadh/combos"
+)
+
+type eventRecorder struct {
+	recordCallbacks
+}
+
+func (r *eventRecorder) record(event string, n *Node) error {
+	r.events = append(r.events, fmt.Sprintf("%v %v", event, n.Label))
+	return nil
+}
+
+func (r *eventRecorder) OnNode(n *Node) error         { return r.record("node", n) }
+func (r *eventRecorder) OnEdge(n *Node) error         { return r.record("edge", n) }
+func (r *eventRecorder) OnNodeDefaults(n *Node) error { return r.record("node defaults", n) }
+func (r *eventRecorder) OnEdgeDefaults(n *Node) error { return r.record("edge defaults", n) }
+func (r *eventRecorder) OnGraphAttr(n *Node) error    { return r.record("graph attr", n) }
+func (r *eventRecorder) OnComment(n *Node) error      { return r.record("comment", n) }
+
+func (r *eventRecorder) ExitSubgraph(n *Node) error {
+	r.events = append(r.events, fmt.Sprintf("exit subgraph %v", idValue(n.Get(0))))
+	return nil
+}
+
+const eventText = `digraph g {
+	// the defaults
+	node [shape=box]
+	edge [w=1]
+	graph [rankdir=LR]
+	label=x
+	a
+	a -> b
+	subgraph s { c }
+}`
+
+var eventsExpected = []string{
+	"enter Graph",
+	"comment COMMENT",
+	"node defaults NodeAttrs",
+	"edge defaults EdgeAttrs",
+	"graph attr GraphAttrs",
+	"graph attr Attr",
+	"node Node",
+	"edge Edge",
+	"enter SubGraph",
+	"node Node",
+	"exit subgraph s",
+	"stmt SubGraph",
+	"exit Graph",
+}
+
+func TestEventCallbacks(x *testing.T) {
+	t := (*test.T)(x)
+	call := &eventRecorder{}
+	t.AssertNil(StreamParse([]byte(eventText), call))
+	assertSameEvents(t, eventsExpected, call.events)
+}
+
+func TestEventCallbacksReader(x *testing.T) {
+	t := (*test.T)(x)
+	call := &eventRecorder{}
+	t.AssertNil(StreamParseReader(strings.NewReader(eventText), call))
+	assertSameEvents(t, eventsExpected, call.events)
+}
+
+func TestEventCallbacksStrict(x *testing.T) {
+	t := (*test.T)(x)
+	call := &eventRecorder{}
+	p := NewDotParser(nil)
+	p.Options.Strict = StrictReport
+	p.Callbacks = p.StrictCallbacks(call)
+	_, err := p.Parse([]byte(`strict graph { a -- b; b -- a }`))
+	t.AssertNil(err)
+	assertSameEvents(t, []string{"enter Graph", "edge Edge", "exit Graph"}, call.events)
+	t.Assert(len(p.Diagnostics) == 1, "expected 1 diagnostic got %v", p.Diagnostics)
+}
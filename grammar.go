@@ -3,15 +3,45 @@ package dot
 import (
 	"fmt"
 	"strings"
+	"sync"
 )
 
 import (
 	"github.com/timtadh/combos"
+	"github.com/timtadh/dot/ast"
 )
 
+// Grammars are costly to build so they are reused. A grammar is only used by
+// one parse at a time.
+var grammars = sync.Pool{
+	New: func() interface{} {
+		return DotGrammar()
+	},
+}
+
+// A grammar for a single parse, return it with putGrammar once done.
+func getGrammar() *combos.Grammar {
+	return grammars.Get().(*combos.Grammar)
+}
+
+func putGrammar(g *combos.Grammar) {
+	grammars.Put(g)
+}
+
+// Build the grammar of dot. Building the grammar is costly, Parse and the
+// other functions of the package reuse the grammars they build.
 func DotGrammar() *combos.Grammar {
 	g := combos.NewGrammar(Tokens, TokenIds)
 
+	// An empty production. The node is built for each match as the actions
+	// modify the nodes they are given.
+	empty := func(label string) combos.Consumer {
+		return g.Concat()(
+			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
+				return combos.NewNode(label), nil
+			})
+	}
+
 	g.Start("Graphs")
 
 	g.AddRule("Graphs",
@@ -21,9 +51,9 @@ func DotGrammar() *combos.Grammar {
 					graphs := combos.NewNode("Graphs").AddKid(nodes[0])
 					graphs.Children = append(graphs.Children,
 						nodes[1].Children...)
-					return graphs, nil
+					return locate(graphs, nil, nodes[0], nodes[1]), nil
 				}),
-			g.Epsilon(combos.NewNode("Graphs")),
+			empty("Graphs"),
 		))
 
 	g.AddRule("Graph",
@@ -44,21 +74,21 @@ func DotGrammar() *combos.Grammar {
 		g.Concat(g.P("GraphStart"), g.P("GraphBody"), gEnd)(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 				stmt := nodes[0].AddKid(nodes[1])
-				// force a re-computation of the location of the graph
-				// incase the partial action computed it
-				stmt.SetLocation(nil)
-				return stmt, nil
+				// the location given by GraphStart ends with the header
+				return locate(stmt, nil, graphHeader(stmt, nodes[1])...), nil
 			}),
 	)
 
 	g.AddRule("GraphStart",
 		g.Alt(
-			g.Concat(g.P("STRICT"), g.P("GraphType"), g.P("ID"))(
+			g.Concat(g.P("STRICT"), g.P("GraphType"), g.P("Identifier"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
+					d.directed = nodes[1].Label == "DIGRAPH"
 					stmt := combos.NewNode("Graph").
 						AddKid(nodes[1].AddKid(nodes[0])).
 						AddKid(nodes[2])
+					locate(stmt, nil, graphHeader(stmt)...)
 					if d.Callbacks != nil {
 						err := d.Callbacks.Enter("Graph", stmt)
 						if err != nil {
@@ -70,9 +100,13 @@ func DotGrammar() *combos.Grammar {
 			g.Concat(g.P("STRICT"), g.P("GraphType"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
+					d.directed = nodes[1].Label == "DIGRAPH"
+					id := combos.NewValueNode("ID", ID{Value: d.NextName("graph"), Anonymous: true})
+					id.SetLocation(endOf(nodes[1].Location()))
 					stmt := combos.NewNode("Graph").
 						AddKid(nodes[1].AddKid(nodes[0])).
-						AddKid(combos.NewNode(d.NextName("graph")))
+						AddKid(id)
+					locate(stmt, nil, graphHeader(stmt)...)
 					if d.Callbacks != nil {
 						err := d.Callbacks.Enter("Graph", stmt)
 						if err != nil {
@@ -81,12 +115,14 @@ func DotGrammar() *combos.Grammar {
 					}
 					return stmt, nil
 				}),
-			g.Concat(g.P("GraphType"), g.P("ID"))(
+			g.Concat(g.P("GraphType"), g.P("Identifier"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
+					d.directed = nodes[0].Label == "DIGRAPH"
 					stmt := combos.NewNode("Graph").
 						AddKid(nodes[0]).
 						AddKid(nodes[1])
+					locate(stmt, nil, graphHeader(stmt)...)
 					if d.Callbacks != nil {
 						err := d.Callbacks.Enter("Graph", stmt)
 						if err != nil {
@@ -98,9 +134,13 @@ func DotGrammar() *combos.Grammar {
 			g.Concat(g.P("GraphType"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
+					d.directed = nodes[0].Label == "DIGRAPH"
+					id := combos.NewValueNode("ID", ID{Value: d.NextName("graph"), Anonymous: true})
+					id.SetLocation(endOf(nodes[0].Location()))
 					stmt := combos.NewNode("Graph").
 						AddKid(nodes[0]).
-						AddKid(combos.NewValueNode("ID", d.NextName("graph")))
+						AddKid(id)
+					locate(stmt, nil, graphHeader(stmt)...)
 					if d.Callbacks != nil {
 						err := d.Callbacks.Enter("Graph", stmt)
 						if err != nil {
@@ -111,6 +151,48 @@ func DotGrammar() *combos.Grammar {
 				}),
 		))
 
+	// Quoted strings may be joined with + to form a single ID
+	g.AddRule("Identifier",
+		g.Alt(
+			g.Concat(g.P("ID"), g.P("+"), g.P("Identifier"))(
+				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
+					left, right := nodes[0], nodes[2]
+					l, _ := left.Value.(ID)
+					r, _ := right.Value.(ID)
+					if l.Kind != ast.Quoted {
+						return nil, left.Error("Only quoted strings may be joined with +, got %v", left)
+					}
+					if r.Kind != ast.Quoted {
+						return nil, right.Error("Only quoted strings may be joined with +, got %v", right)
+					}
+					// the raw ID is the text of the join as written, or the
+					// two raw IDs joined with ` + ` when the text is not at
+					// hand. A comment cannot sit inside of a join.
+					at := extent(left, nodes[1], right)
+					raw, ok := ctx.(*DotParser).source(at)
+					if !ok {
+						raw = l.Raw + " + " + r.Raw
+					}
+					id := combos.NewValueNode("ID", ID{
+						Kind:  ast.Quoted,
+						Value: l.Value + r.Value,
+						Raw:   raw,
+					})
+					id.SetLocation(at)
+					if err := ctx.(*DotParser).checkID(id); err != nil {
+						return nil, err
+					}
+					return id, nil
+				}),
+			g.Concat(g.P("ID"))(
+				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
+					if err := ctx.(*DotParser).checkID(nodes[0]); err != nil {
+						return nil, err
+					}
+					return nodes[0], nil
+				}),
+		))
+
 	g.AddRule("GraphType",
 		g.Alt(
 			g.P("GRAPH"),
@@ -120,9 +202,7 @@ func DotGrammar() *combos.Grammar {
 	g.AddRule("GraphBody",
 		(g.Concat(g.P("{"), g.P("Stmts"), g.P("}"))(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
-				n := nodes[1]
-				n.SetLocation(n.Location().Join(nodes[0].Location(), nodes[2].Location()))
-				return n, nil
+				return locate(nodes[1], nil, nodes[0], nodes[1], nodes[2]), nil
 			})),
 	)
 
@@ -132,10 +212,16 @@ func DotGrammar() *combos.Grammar {
 		}
 		nodes := make([]*combos.Node, 0, len(n.Children)-1)
 		attrs := n.Get(-1)
-		for i := 0; i < len(n.Children)-1; i++ {
-			nodes = append(nodes, n.Get(i).AddKid(attrs))
+		last := len(n.Children) - 2
+		locate(attrs, n.Get(last).Get(1), attrs)
+		// the attributes are shared by the edges but only the last edge of
+		// a chain, `b -> c [...]` of `a -> b -> c [...]`, spans them
+		for i := 0; i < last; i++ {
+			e := n.Get(i)
+			nodes = append(nodes, locate(e.AddKid(attrs), nil, e.Get(0), e.Get(1)))
 		}
-		return nodes
+		e := n.Get(last)
+		return append(nodes, locate(e.AddKid(attrs), nil, e.Get(0), e.Get(1), attrs))
 	}
 
 	g.AddRule("Stmts",
@@ -143,23 +229,26 @@ func DotGrammar() *combos.Grammar {
 			g.Concat(g.P("Stmt"), g.P("Stmts"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
-					if d.Callbacks != nil {
+					if d.Callbacks != nil && !d.keep {
 						return combos.NewNode("Stmts"), nil
 					} else {
 						stmts := nodes[0]
 						stmts.Children = append(stmts.Children, nodes[1].Children...)
-						return stmts, nil
+						return locate(stmts, nil, nodes[0], nodes[1]), nil
 					}
 				}),
-			g.Epsilon(combos.NewNode("Stmts")),
+			empty("Stmts"),
 		))
 
 	g.AddRule("Stmt",
-		g.Concat(g.P("Stmt'"), g.Alt(g.P(";"), g.Epsilon(combos.NewNode("e"))))(
+		g.Concat(g.P("Stmt'"), g.Alt(g.P(";"), empty("e")))(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 				d := ctx.(*DotParser)
 				stmts := combos.NewNode("Stmts")
 				for _, stmt := range unwrapMultiple(nodes[0]) {
+					if err := d.checkStmt(stmt); err != nil {
+						return nil, err
+					}
 					stmts.AddKid(stmt)
 					if d.Callbacks != nil {
 						err := d.Callbacks.Stmt(stmt)
@@ -168,7 +257,7 @@ func DotGrammar() *combos.Grammar {
 						}
 					}
 				}
-				return stmts, nil
+				return locate(stmts, nil, stmts.Children...), nil
 			}),
 	)
 
@@ -206,12 +295,13 @@ func DotGrammar() *combos.Grammar {
 
 	// NodeId AttrLists
 	nodeAction := func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
+		locate(nodes[1], nodes[0], nodes[1])
 		n := combos.NewNode("Node").AddKid(nodes[0]).AddKid(nodes[1])
-		return n, nil
+		return locate(n, nil, nodes[0], nodes[1]), nil
 	}
 
 	g.AddRule("StmtIDStart",
-		g.Concat(g.P("ID"), g.Alt(g.P("AttrStmtCont"), g.P("NodeIdCont")))(
+		g.Concat(g.P("Identifier"), g.Alt(g.P("AttrStmtCont"), g.P("NodeIdCont")))(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 				switch nodes[1].Label {
 				case "Attrs":
@@ -233,7 +323,7 @@ func DotGrammar() *combos.Grammar {
 				case "AttrStmtCont":
 					stmt := combos.NewNode("Attr").
 						AddKid(nodes[0]).AddKid(nodes[1].Get(1))
-					return stmt, nil
+					return locate(stmt, nil, nodes[0], nodes[1].Get(1)), nil
 				default:
 					return nil, nodes[1].Error("Unexpected node %v", nodes[1])
 				}
@@ -241,7 +331,7 @@ func DotGrammar() *combos.Grammar {
 	)
 
 	g.AddRule("AttrStmtCont",
-		g.Concat(g.P("="), g.P("ID"))(
+		g.Concat(g.P("="), g.P("Identifier"))(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 				n := combos.NewNode("AttrStmtCont").AddKid(nodes[0]).AddKid(nodes[1])
 				return n, nil
@@ -260,7 +350,7 @@ func DotGrammar() *combos.Grammar {
 		))
 
 	g.AddRule("StmtSubGraphStart",
-		g.Concat(g.P("SubGraph"), g.Alt(g.P("EdgeCont"), g.Epsilon(combos.NewNode("e"))))(
+		g.Concat(g.P("SubGraph"), g.Alt(g.P("EdgeCont"), empty("e")))(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 				if nodes[1].Label == "e" {
 					return nodes[0], nil
@@ -272,18 +362,18 @@ func DotGrammar() *combos.Grammar {
 
 	g.AddRule("AttrStmt",
 		g.Alt(
-			g.Concat(g.P("ID"), g.P("="), g.P("ID"))(
+			g.Concat(g.P("Identifier"), g.P("="), g.P("Identifier"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					stmt := combos.NewNode("Attr").
 						AddKid(nodes[0]).AddKid(nodes[2])
-					return stmt, nil
+					return locate(stmt, nil, nodes[0], nodes[2]), nil
 				}),
 			g.Concat(g.P("AttrType"), g.P("AttrLists"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					name := nodes[0].Label[:1] + strings.ToLower(nodes[0].Label[1:])
 					stmt := combos.NewNode(name + "Attrs")
 					stmt.Children = nodes[1].Children
-					return stmt, nil
+					return locate(stmt, nil, nodes[0], nodes[1]), nil
 				}),
 		))
 
@@ -301,17 +391,15 @@ func DotGrammar() *combos.Grammar {
 					attrs := combos.NewNode("Attrs")
 					attrs.Children = append(attrs.Children, nodes[0].Children...)
 					attrs.Children = append(attrs.Children, nodes[1].Children...)
-					return attrs, nil
+					return locate(attrs, nil, nodes[0], nodes[1]), nil
 				}),
-			g.Epsilon(combos.NewNode("Attrs")),
+			empty("Attrs"),
 		))
 
 	g.AddRule("AttrList",
 		g.Concat(g.P("["), g.P("AttrExprs"), g.P("]"))(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
-				n := nodes[1]
-				n.SetLocation(n.Location().Join(nodes[0].Location(), nodes[2].Location()))
-				return n, nil
+				return locate(nodes[1], nil, nodes[0], nodes[1], nodes[2]), nil
 			}),
 	)
 
@@ -321,26 +409,26 @@ func DotGrammar() *combos.Grammar {
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					attrs := combos.NewNode("Attrs").AddKid(nodes[0])
 					attrs.Children = append(attrs.Children, nodes[1].Children...)
-					return attrs, nil
+					return locate(attrs, nil, nodes[0], nodes[1]), nil
 				}),
-			g.Epsilon(combos.NewNode("Attrs")),
+			empty("Attrs"),
 		))
 
 	g.AddRule("AttrExpr",
 		g.Alt(
-			g.Concat(g.P("ID"), g.P("="), g.P("ID"), g.P(";"))(
+			g.Concat(g.P("Identifier"), g.P("="), g.P("Identifier"), g.P(";"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					stmt := combos.NewNode("Attr").
 						AddKid(nodes[0]).AddKid(nodes[2])
 					return stmt, nil
 				}),
-			g.Concat(g.P("ID"), g.P("="), g.P("ID"), g.P(","))(
+			g.Concat(g.P("Identifier"), g.P("="), g.P("Identifier"), g.P(","))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					stmt := combos.NewNode("Attr").
 						AddKid(nodes[0]).AddKid(nodes[2])
 					return stmt, nil
 				}),
-			g.Concat(g.P("ID"), g.P("="), g.P("ID"))(
+			g.Concat(g.P("Identifier"), g.P("="), g.P("Identifier"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					stmt := combos.NewNode("Attr").
 						AddKid(nodes[0]).AddKid(nodes[2])
@@ -354,12 +442,12 @@ func DotGrammar() *combos.Grammar {
 
 	g.AddRule("NodeId",
 		g.Alt(
-			g.Concat(g.P("ID"), g.P("Port"))(
+			g.Concat(g.P("Identifier"), g.P("Port"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					n := nodes[0].AddKid(nodes[1])
 					return n, nil
 				}),
-			g.P("ID"),
+			g.P("Identifier"),
 		))
 
 	// TODO: Add Port constratins
@@ -367,9 +455,9 @@ func DotGrammar() *combos.Grammar {
 	//                    "w", "nw", "c", "_"
 	g.AddRule("Port",
 		g.Alt(
-			g.Concat(g.P(":"), g.P("ID"), g.P(":"), g.P("ID"))(
+			g.Concat(g.P(":"), g.P("Identifier"), g.P(":"), g.P("Identifier"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
-					port2 := nodes[3].Value.(string)
+					port2 := idValue(nodes[3])
 					switch port2 {
 					case "n", "ne", "e", "se", "s", "sw",
 						"w", "nw", "c", "_":
@@ -378,12 +466,12 @@ func DotGrammar() *combos.Grammar {
 						return nil, nodes[3].Error(fmt.Sprintf("2nd port id must be a dir (n, ne, e, se, s, se, nw, c, _) got : %v", port2))
 					}
 					n := combos.NewNode("Port").AddKid(nodes[1]).AddKid(nodes[3])
-					return n, nil
+					return locate(n, nil, nodes[0], nodes[3]), nil
 				}),
-			g.Concat(g.P(":"), g.P("ID"))(
+			g.Concat(g.P(":"), g.P("Identifier"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					n := combos.NewNode("Port").AddKid(nodes[1])
-					return n, nil
+					return locate(n, nil, nodes[0], nodes[1]), nil
 				}),
 		))
 
@@ -450,10 +538,28 @@ func DotGrammar() *combos.Grammar {
 			g.Epsilon(nil),
 		))
 
+	// -> is only valid in a digraph and -- only in a graph
+	edgeOp := func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
+		d := ctx.(*DotParser)
+		op := nodes[0]
+		if (op.Label == "->") == d.directed {
+			return op, nil
+		}
+		kind := "graph"
+		if d.directed {
+			kind = "digraph"
+		}
+		if d.Options.Lenient {
+			d.warn(op, "Edge operator %v is not valid in a %v", op.Label, kind)
+			return op, nil
+		}
+		return nil, op.Error("Edge operator %v is not valid in a %v", op.Label, kind)
+	}
+
 	g.AddRule("EdgeOp",
 		g.Alt(
-			g.P("->"),
-			g.P("--"),
+			g.Concat(g.P("->"))(edgeOp),
+			g.Concat(g.P("--"))(edgeOp),
 		))
 
 	g.AddRule("SubGraph",
@@ -461,23 +567,31 @@ func DotGrammar() *combos.Grammar {
 			g.P("SubGraphStart"), g.P("GraphBody"))(
 			func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 				d := ctx.(*DotParser)
+				start, body := nodes[1], nodes[2]
+				if id := start.Get(0); id.Location() == nil {
+					// `{ ... }` is named at its brace
+					id.SetLocation(startOf(body.Location()))
+				}
+				stmt := start.AddKid(body)
+				stmt.SetLocation(join(extent(start, start.Get(0)), body.Location()))
 				if d.Callbacks != nil {
 					err := d.Callbacks.Exit("SubGraph")
 					if err != nil {
 						return nil, nodes[0].Error("Stream callback error").Chain(err)
 					}
 				}
-				return nodes[1].AddKid(nodes[2]), nil
+				return stmt, nil
 			}),
 	)
 
 	g.AddRule("SubGraphStart",
 		g.Alt(
-			g.Concat(g.P("SUBGRAPH"), g.P("ID"))(
+			g.Concat(g.P("SUBGRAPH"), g.P("Identifier"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
 					stmt := combos.NewNode("SubGraph").
 						AddKid(nodes[1])
+					locate(stmt, nil, nodes[0], nodes[1])
 					if d.Callbacks != nil {
 						err := d.Callbacks.Enter("SubGraph", stmt)
 						if err != nil {
@@ -489,8 +603,11 @@ func DotGrammar() *combos.Grammar {
 			g.Concat(g.P("SUBGRAPH"))(
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
+					id := combos.NewValueNode("ID", ID{Value: d.NextName("subgraph"), Anonymous: true})
+					id.SetLocation(endOf(nodes[0].Location()))
 					stmt := combos.NewNode("SubGraph").
-						AddKid(combos.NewValueNode("ID", d.NextName("subgraph")))
+						AddKid(id)
+					locate(stmt, nil, nodes[0], id)
 					if d.Callbacks != nil {
 						err := d.Callbacks.Enter("SubGraph", stmt)
 						if err != nil {
@@ -503,7 +620,7 @@ func DotGrammar() *combos.Grammar {
 				func(ctx interface{}, nodes ...*combos.Node) (*combos.Node, *combos.ParseError) {
 					d := ctx.(*DotParser)
 					stmt := combos.NewNode("SubGraph").
-						AddKid(combos.NewValueNode("ID", d.NextName("subgraph")))
+						AddKid(combos.NewValueNode("ID", ID{Value: d.NextName("subgraph"), Anonymous: true}))
 					if d.Callbacks != nil {
 						err := d.Callbacks.Enter("SubGraph", stmt)
 						if err != nil {
@@ -516,3 +633,11 @@ func DotGrammar() *combos.Grammar {
 
 	return g
 }
+
+// The nodes a Graph spans: its kind, strict or not, its ID and body
+func graphHeader(graph *combos.Node, body ...*combos.Node) []*combos.Node {
+	kind := graph.Get(0)
+	nodes := append([]*combos.Node{kind}, kind.Children...)
+	nodes = append(nodes, graph.Get(1))
+	return append(nodes, body...)
+}
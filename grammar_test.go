@@ -3,9 +3,14 @@ package dot
 import "testing"
 import "github.com/timtadh/data-structures/test"
 
+import (
+	"fmt"
+)
+
 import (
 	. "github.com/timtadh/combos"
 	"github.com/timtadh/data-structures/errors"
+	"github.com/timtadh/dot/ast"
 )
 
 type logCall struct{}
@@ -60,6 +65,43 @@ func TestEmptyGraphs(x *testing.T) {
 	t.Assert(n.Equal(e), "expected %v got %v", e, n)
 }
 
+// Every form of graph header gives the Graph the same ID node
+func TestGraphStartForms(x *testing.T) {
+	t := (*test.T)(x)
+	forms := []struct {
+		text      string
+		strict    bool
+		anonymous bool
+	}{
+		{`strict digraph g {}`, true, false},
+		{`strict digraph {}`, true, true},
+		{`digraph g {}`, false, false},
+		{`digraph {}`, false, true},
+	}
+	for _, form := range forms {
+		kind := NewNode("DIGRAPH")
+		if form.strict {
+			kind.AddKid(NewNode("STRICT"))
+		}
+		e := NewNode("Graphs").
+			AddKid(NewNode("Graph").
+				AddKid(kind).
+				AddKid(NewNode("ID")).
+				AddKid(NewNode("Stmts")))
+		n, err := Parse([]byte(form.text))
+		t.AssertNil(err)
+		t.Assert(n.Equal(e), "%v: expected %v got %v", form.text, e, n)
+		id, ok := n.Get(0).Get(1).Value.(ID)
+		t.Assert(ok, "%v: expected an ID value got %v", form.text, n.Get(0).Get(1))
+		t.Assert(id.Anonymous == form.anonymous, "%v: expected anonymous %v got %v", form.text, form.anonymous, id.Anonymous)
+		if form.anonymous {
+			t.Assert(id.Value == "graph1", "%v: expected graph1 got %v", form.text, id.Value)
+		} else {
+			t.Assert(id.Value == "g", "%v: expected g got %v", form.text, id.Value)
+		}
+	}
+}
+
 func TestGraphNode(x *testing.T) {
 	t := (*test.T)(x)
 	e := NewNode("Graphs").
@@ -271,3 +313,205 @@ func TestGraphNoErr(x *testing.T) {
 	`), &logCall{})
 	t.AssertNil(err)
 }
+
+func TestEdgeOpMismatch(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{
+		`digraph { a -- b }`,
+		`graph { a -> b }`,
+		`strict graph { a -- b -> c }`,
+		`digraph { subgraph { a -- b } }`,
+	} {
+		_, err := Parse([]byte(text))
+		t.Assert(err != nil, "expected an error for %v", text)
+		t.Log(err)
+	}
+}
+
+func TestEdgeOpMatch(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{
+		`digraph { a -> b }`,
+		`graph { a -- b }`,
+		`strict graph { a -- b -- c }`,
+		`graph { a -- b } digraph { a -> b }`,
+	} {
+		_, err := Parse([]byte(text))
+		t.AssertNil(err)
+	}
+}
+
+func TestEdgeOpLenient(x *testing.T) {
+	t := (*test.T)(x)
+	p := NewDotParser(nil)
+	p.Options.Lenient = true
+	n, err := p.Parse([]byte(`graph {
+		a -> b
+		b -- c
+	}`))
+	t.AssertNil(err)
+	t.Assert(n != nil, "expected a tree")
+	t.Assert(len(p.Diagnostics) == 1, "expected 1 warning got %v", p.Diagnostics)
+	d := p.Diagnostics[0]
+	t.Assert(d.Severity == SeverityWarning, "expected a warning got %v", d)
+	t.Assert(d.Location.StartLine == 2, "expected line 2 got %v", d)
+	t.Log(d)
+}
+
+func TestDiagnosticsReset(x *testing.T) {
+	t := (*test.T)(x)
+	p := NewDotParser(nil)
+	p.Options.Lenient = true
+	for i := 0; i < 2; i++ {
+		_, err := p.Parse([]byte(`graph { a -> b }`))
+		t.AssertNil(err)
+		t.Assert(len(p.Diagnostics) == 1, "parse %v: expected 1 warning got %v", i, p.Diagnostics)
+	}
+	_, err := p.Parse([]byte(`graph { a -- b }`))
+	t.AssertNil(err)
+	t.Assert(len(p.Diagnostics) == 0, "expected the warnings of the last parse to be dropped got %v", p.Diagnostics)
+	_, _, err = p.ParseRecover([]byte(`graph { a -> b }`))
+	t.AssertNil(err)
+	t.Assert(len(p.Diagnostics) == 1, "expected 1 warning got %v", p.Diagnostics)
+	_, _, err = p.ParseRecover([]byte(`graph { a -- b }`))
+	t.AssertNil(err)
+	t.Assert(len(p.Diagnostics) == 0, "expected the warnings of the last parse to be dropped got %v", p.Diagnostics)
+}
+
+func TestConcatID(x *testing.T) {
+	t := (*test.T)(x)
+	e := NewNode("Graphs").
+		AddKid(NewNode("Graph").
+			AddKid(NewNode("DIGRAPH")).
+			AddKid(NewNode("ID")).
+			AddKid(NewNode("Stmts").
+				AddKid(NewNode("Node").
+					AddKid(NewNode("ID")).
+					AddKid(NewNode("Attrs").
+						AddKid(NewNode("Attr").
+							AddKid(NewNode("ID")).
+							AddKid(NewNode("ID")))))))
+	n, err := Parse([]byte(`digraph { "a" + "b" [label="abc" +
+		"def" + "ghi"] }`))
+	t.AssertNil(err)
+	t.Assert(n.Equal(e), "expected %v got %v", e, n)
+	node := n.Get(0).Get(2).Get(0)
+	name := node.Get(0).Value.(ID)
+	t.Assert(name.Value == "ab", "expected ab got %v", name.Value)
+	t.Assert(name.Raw == `"a" + "b"`, "expected the raw text as written got %q", name.Raw)
+	label := node.Get(1).Get(0).Get(1)
+	t.Assert(idValue(label) == "abcdefghi", "expected abcdefghi got %v", idValue(label))
+	l := label.Location()
+	t.Assert(l.StartLine == 1 && l.EndLine == 2, "expected the location to span both lines got %v", l)
+	raw := label.Value.(ID).Raw
+	t.Assert(raw == "\"abc\" +\n\t\t\"def\" + \"ghi\"", "expected the raw text as written got %q", raw)
+
+	_, err = Parse([]byte(`digraph { "a"+/* b */"c" }`))
+	t.Assert(err != nil, "expected a comment inside of a join to be refused")
+}
+
+func TestConcatIDNotQuoted(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{
+		`digraph { a + "b" }`,
+		`digraph { "a" + b }`,
+		`digraph { x = "a" + }`,
+	} {
+		_, err := Parse([]byte(text))
+		t.Assert(err != nil, "expected an error for %v", text)
+	}
+}
+
+func TestCaselessKeywords(x *testing.T) {
+	t := (*test.T)(x)
+	f, err := ParseAST([]byte(`STRICT DiGraph G { NODE [shape=box]; Edge [w=1]; SubGraph s { a -> b } }`))
+	t.AssertNil(err)
+	g := f.Decls[0].(*ast.Graph)
+	t.Assert(g.Strict && g.Directed, "expected a strict digraph got %v", g)
+	t.Assert(len(g.Stmts) == 3, "expected 3 stmts got %v", len(g.Stmts))
+	t.Assert(g.Stmts[0].(*ast.AttrStmt).Kind == ast.NodeAttrs, "expected node attrs")
+	t.Assert(g.Stmts[1].(*ast.AttrStmt).Kind == ast.EdgeAttrs, "expected edge attrs")
+	t.Assert(g.Stmts[2].(*ast.Subgraph).ID.Value == "s", "expected subgraph s")
+}
+
+func TestEmptyNodesNotShared(x *testing.T) {
+	t := (*test.T)(x)
+	for i := 0; i < 2; i++ {
+		n, err := Parse([]byte(`digraph { } graph { a [] }`))
+		t.AssertNil(err)
+		a, b := n.Get(0).Get(2), n.Get(1).Get(2)
+		t.Assert(a != b, "the empty bodies should be distinct nodes")
+		t.Assert(len(a.Children) == 0, "expected an empty body got %v", a)
+	}
+}
+
+func TestGrammarConcurrent(x *testing.T) {
+	t := (*test.T)(x)
+	texts := []string{
+		`digraph { a -> b [w=1]; subgraph s { c } }`,
+		`graph g { a -- {b c} -- d }`,
+		`strict digraph { node [shape=box]; x -> y }`,
+	}
+	expected := make([]*Node, len(texts))
+	for i, text := range texts {
+		n, err := Parse([]byte(text))
+		t.AssertNil(err)
+		expected[i] = n
+	}
+	runConcurrent(t, 8, 50, func(run int) error {
+		j := run % len(texts)
+		n, err := Parse([]byte(texts[j]))
+		if err == nil && !n.Equal(expected[j]) {
+			err = fmt.Errorf("expected %v got %v", expected[j], n)
+		}
+		return err
+	})
+}
+
+const benchText = `digraph G {
+	node [shape=box];
+	a -> b -> c [label="x"];
+	subgraph cluster_0 { d; e -> f }
+	g:p:n -> h;
+}`
+
+// The cost of building the grammar, paid on every parse before it was
+// cached.
+func BenchmarkDotGrammar(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		DotGrammar()
+	}
+}
+
+func BenchmarkParseUncached(b *testing.B) {
+	text := []byte(benchText)
+	for i := 0; i < b.N; i++ {
+		s, err := Lexer.Scanner(text)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := DotGrammar().Parse(s, NewDotParser(nil)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	text := []byte(benchText)
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(text); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseParallel(b *testing.B) {
+	text := []byte(benchText)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := Parse(text); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,403 @@
+package dot
+
+import (
+	"fmt"
+	"strings"
+)
+
+import (
+	"github.com/timtadh/dot/ast"
+)
+
+// Attrs maps attribute names to their values.
+type Attrs map[string]Value
+
+// The value of an attribute. Kind is that of the ID the value was written
+// as: graphviz renders an HTML label differently from a quoted one.
+type Value struct {
+	Value string
+	Kind  ast.IDKind
+}
+
+func (v Value) String() string {
+	return v.Value
+}
+
+func idOf(id *ast.ID) Value {
+	return Value{Value: id.Value, Kind: id.Kind}
+}
+
+func (a Attrs) copy() Attrs {
+	c := make(Attrs, len(a))
+	for k, v := range a {
+		c[k] = v
+	}
+	return c
+}
+
+func (a Attrs) merge(attrs []*ast.Attr) {
+	for _, attr := range attrs {
+		a[attr.Name.Value] = idOf(attr.Value)
+	}
+}
+
+// Graph is the semantic model of a graph or subgraph. Unlike the syntax tree
+// the model has the default attribute statements applied, every node
+// appears once no matter how many times it was mentioned and nodes which
+// were only mentioned in edges exist.
+type Graph struct {
+	Name string
+	// NameKind is the kind of ID the name was written as
+	NameKind ast.IDKind
+	// Anonymous graphs and subgraphs have a name generated by the parser,
+	// an anonymous subgraph is never the same as any other subgraph.
+	Anonymous bool
+	Strict    bool
+	Directed  bool
+	// Attrs are the attributes of the graph itself, set with `graph [...]`
+	// or `name=value` statements. A subgraph starts with a copy of the
+	// attributes of its parent at the point it is declared.
+	Attrs Attrs
+	// NodeAttrs and EdgeAttrs are the defaults in effect at the end of the
+	// graph's body.
+	NodeAttrs Attrs
+	EdgeAttrs Attrs
+	// The nodes and edges that belong to this graph (including those
+	// belonging to its subgraphs) in the order they were first mentioned.
+	Nodes     []*Vertex
+	Edges     []*Edge
+	Subgraphs []*Graph
+	// Parent is nil for the root graph.
+	Parent *Graph
+
+	root      *Graph
+	members   map[*Vertex]bool
+	edges     map[*Edge]bool
+	nodes     map[string]*Vertex // only on the root, nodes by name
+	subgraphs map[string]*Graph  // only on the root, named subgraphs by name
+}
+
+// A node of a graph. A Vertex is shared by a graph and all of the subgraphs
+// it was mentioned in.
+type Vertex struct {
+	Name string
+	// NameKind is the kind of ID the node was first named with
+	NameKind ast.IDKind
+	Attrs    Attrs
+}
+
+// An edge between two nodes. Ports are of the form `port[:compass]` and are
+// empty when the edge was not attached to a port.
+type Edge struct {
+	From     *Vertex
+	To       *Vertex
+	FromPort string
+	ToPort   string
+	Attrs    Attrs
+}
+
+// Parse the text and build a semantic Graph for each graph in it.
+func ParseGraphs(text []byte) ([]*Graph, error) {
+	return NewDotParser(nil).ParseGraphs(text)
+}
+
+// Build the semantic model of a parsed graph. The duplicate edges of a
+// strict graph are merged.
+func NewGraph(g *ast.Graph) (*Graph, error) {
+	return NewDotParser(nil).NewGraph(g)
+}
+
+// Parse the text with the options of the parser and build a semantic Graph
+// for each graph in it.
+func (d *DotParser) ParseGraphs(text []byte) ([]*Graph, error) {
+	n, err := d.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	f, err := BuildAST(n)
+	if err != nil {
+		return nil, err
+	}
+	graphs := make([]*Graph, 0, len(f.Decls))
+	for _, decl := range f.Decls {
+		if g, ok := decl.(*ast.Graph); ok {
+			graph, err := d.NewGraph(g)
+			if err != nil {
+				return nil, err
+			}
+			graphs = append(graphs, graph)
+		}
+	}
+	return graphs, nil
+}
+
+// Build the semantic model of a parsed graph. The duplicate edges of a
+// strict graph are handled as given by Options.Strict.
+func (d *DotParser) NewGraph(g *ast.Graph) (*Graph, error) {
+	root := &Graph{
+		Name:      g.ID.Value,
+		NameKind:  g.ID.Kind,
+		Anonymous: g.ID.Anonymous,
+		Strict:    g.Strict,
+		Directed:  g.Directed,
+		Attrs:     make(Attrs),
+		NodeAttrs: make(Attrs),
+		EdgeAttrs: make(Attrs),
+		members:   make(map[*Vertex]bool),
+		edges:     make(map[*Edge]bool),
+		nodes:     make(map[string]*Vertex),
+		subgraphs: make(map[string]*Graph),
+	}
+	root.root = root
+	b := &graphBuilder{
+		d:     d,
+		edges: make(map[[2]*Vertex]*Edge),
+	}
+	if err := b.stmts(root, g.Stmts); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Whether the graph is a cluster, that is a subgraph whose name starts with
+// "cluster".
+func (g *Graph) IsCluster() bool {
+	return g.Parent != nil && strings.HasPrefix(g.Name, "cluster")
+}
+
+// The clusters directly beneath this graph. Clusters nested in
+// non-cluster subgraphs are included, clusters nested in other clusters are
+// not.
+func (g *Graph) Clusters() []*Graph {
+	clusters := make([]*Graph, 0, len(g.Subgraphs))
+	for _, sg := range g.Subgraphs {
+		if sg.IsCluster() {
+			clusters = append(clusters, sg)
+		} else {
+			clusters = append(clusters, sg.Clusters()...)
+		}
+	}
+	return clusters
+}
+
+// Find a node of this graph by name. Returns nil if the node does not
+// belong to the graph.
+func (g *Graph) Node(name string) *Vertex {
+	n := g.root.nodes[name]
+	if n == nil || !g.members[n] {
+		return nil
+	}
+	return n
+}
+
+// Find a subgraph anywhere beneath the root graph by name. Anonymous
+// subgraphs are not found.
+func (g *Graph) Subgraph(name string) *Graph {
+	return g.root.subgraphs[name]
+}
+
+// The subgraph of g with the given ID. Subgraphs with the same name are the
+// same subgraph unless they are anonymous.
+func (g *Graph) subgraph(id *ast.ID) *Graph {
+	if sg, has := g.root.subgraphs[id.Value]; has && !id.Anonymous {
+		return sg
+	}
+	sg := &Graph{
+		Name:      id.Value,
+		NameKind:  id.Kind,
+		Anonymous: id.Anonymous,
+		Strict:    g.Strict,
+		Directed:  g.Directed,
+		Attrs:     g.Attrs.copy(),
+		NodeAttrs: g.NodeAttrs.copy(),
+		EdgeAttrs: g.EdgeAttrs.copy(),
+		Parent:    g,
+		root:      g.root,
+		members:   make(map[*Vertex]bool),
+		edges:     make(map[*Edge]bool),
+	}
+	g.Subgraphs = append(g.Subgraphs, sg)
+	if !id.Anonymous {
+		g.root.subgraphs[id.Value] = sg
+	}
+	return sg
+}
+
+// Get or create the node in the scope of g. New nodes take the node
+// defaults of g.
+func (g *Graph) node(id *ast.ID) *Vertex {
+	n, has := g.root.nodes[id.Value]
+	if !has {
+		n = &Vertex{Name: id.Value, NameKind: id.Kind, Attrs: g.NodeAttrs.copy()}
+		g.root.nodes[id.Value] = n
+	}
+	for p := g; p != nil; p = p.Parent {
+		if !p.members[n] {
+			p.members[n] = true
+			p.Nodes = append(p.Nodes, n)
+		}
+	}
+	return n
+}
+
+// Add the edge to g and its ancestors. A merged edge of a strict graph may
+// already belong to some of them.
+func (g *Graph) addEdge(e *Edge) {
+	for p := g; p != nil; p = p.Parent {
+		if !p.edges[e] {
+			p.edges[e] = true
+			p.Edges = append(p.Edges, e)
+		}
+	}
+}
+
+type graphBuilder struct {
+	d *DotParser
+	// the edges of a strict graph by their endpoints
+	edges map[[2]*Vertex]*Edge
+}
+
+func (b *graphBuilder) stmts(g *Graph, stmts []ast.Stmt) error {
+	for _, stmt := range stmts {
+		if err := b.stmt(g, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *graphBuilder) stmt(g *Graph, stmt ast.Stmt) error {
+	switch s := stmt.(type) {
+	case *ast.NodeStmt:
+		g.node(s.Node.ID).Attrs.merge(s.Attrs)
+	case *ast.EdgeStmt:
+		if len(s.Vertices) < 2 {
+			return fmt.Errorf("edge with %v vertices", len(s.Vertices))
+		}
+		from, fromPort, err := b.vertex(g, s.Vertices[0])
+		if err != nil {
+			return err
+		}
+		// each vertex of a chain, subgraphs included, is declared once
+		for _, v := range s.Vertices[1:] {
+			to, toPort, err := b.vertex(g, v)
+			if err != nil {
+				return err
+			}
+			for _, f := range from {
+				for _, t := range to {
+					e := &Edge{
+						From:     f,
+						To:       t,
+						FromPort: fromPort,
+						ToPort:   toPort,
+					}
+					if g.Strict {
+						if e = b.strictEdge(g, s, e); e == nil {
+							continue
+						}
+					} else {
+						e.Attrs = g.EdgeAttrs.copy()
+						e.Attrs.merge(s.Attrs)
+					}
+					g.addEdge(e)
+				}
+			}
+			from, fromPort = to, toPort
+		}
+	case *ast.AttrStmt:
+		switch s.Kind {
+		case ast.GraphAttrs:
+			g.Attrs.merge(s.Attrs)
+		case ast.NodeAttrs:
+			g.NodeAttrs.merge(s.Attrs)
+		case ast.EdgeAttrs:
+			g.EdgeAttrs.merge(s.Attrs)
+		}
+	case *ast.Assign:
+		g.Attrs[s.Name.Value] = idOf(s.Value)
+	case *ast.Subgraph:
+		_, err := b.subgraph(g, s)
+		return err
+	case *ast.Comment:
+	default:
+		return fmt.Errorf("unexpected statement %T", stmt)
+	}
+	return nil
+}
+
+// The edge to add to a strict graph in place of e. Either e itself when it
+// is the first edge between its nodes, the first edge with the attributes
+// of e merged into it or nil when the duplicate is dropped. The defaults of
+// the scope only apply to the edge when it is created, as in graphviz.
+func (b *graphBuilder) strictEdge(g *Graph, s *ast.EdgeStmt, e *Edge) *Edge {
+	key := [2]*Vertex{e.From, e.To}
+	if !g.Directed && e.From.Name > e.To.Name {
+		key = [2]*Vertex{e.To, e.From}
+	}
+	first, has := b.edges[key]
+	if !has {
+		e.Attrs = g.EdgeAttrs.copy()
+		e.Attrs.merge(s.Attrs)
+		b.edges[key] = e
+		return e
+	}
+	switch b.d.Options.Strict {
+	case StrictReport:
+		b.d.diagnose(SeverityWarning, location(s.Span),
+			"duplicate edge %v in strict graph %v", edgeName(e, g.Directed), g.root.Name)
+		return nil
+	default:
+		first.Attrs.merge(s.Attrs)
+		if first.From != e.From {
+			e.FromPort, e.ToPort = e.ToPort, e.FromPort
+		}
+		if e.FromPort != "" {
+			first.FromPort = e.FromPort
+		}
+		if e.ToPort != "" {
+			first.ToPort = e.ToPort
+		}
+		return first
+	}
+}
+
+func edgeName(e *Edge, directed bool) string {
+	op := "--"
+	if directed {
+		op = "->"
+	}
+	return quoteID(e.From.Name) + " " + op + " " + quoteID(e.To.Name)
+}
+
+func (b *graphBuilder) subgraph(g *Graph, s *ast.Subgraph) (*Graph, error) {
+	sg := g.subgraph(s.ID)
+	if err := b.stmts(sg, s.Stmts); err != nil {
+		return nil, err
+	}
+	return sg, nil
+}
+
+// The nodes an edge endpoint refers to. A subgraph endpoint refers to every
+// node in the subgraph.
+func (b *graphBuilder) vertex(g *Graph, v ast.Vertex) ([]*Vertex, string, error) {
+	switch x := v.(type) {
+	case *ast.NodeID:
+		port := ""
+		if x.Port != nil {
+			port = x.Port.ID.Value
+			if x.Port.Compass != nil {
+				port += ":" + x.Port.Compass.Value
+			}
+		}
+		return []*Vertex{g.node(x.ID)}, port, nil
+	case *ast.Subgraph:
+		sg, err := b.subgraph(g, x)
+		if err != nil {
+			return nil, "", err
+		}
+		return sg.Nodes, "", nil
+	default:
+		return nil, "", fmt.Errorf("unexpected edge endpoint %T", v)
+	}
+}
@@ -0,0 +1,145 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+func parseGraph(t *test.T, text string) *Graph {
+	graphs, err := ParseGraphs([]byte(text))
+	t.AssertNil(err)
+	t.Assert(len(graphs) == 1, "expected 1 graph got %v", len(graphs))
+	return graphs[0]
+}
+
+func TestGraphModelImplicitNodes(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `digraph { a -> b -> c; b [label=B] }`)
+	t.Assert(g.Directed, "expected a directed graph")
+	t.Assert(len(g.Nodes) == 3, "expected 3 nodes got %v", len(g.Nodes))
+	t.Assert(len(g.Edges) == 2, "expected 2 edges got %v", len(g.Edges))
+	t.Assert(g.Node("b").Attrs["label"].Value == "B", "expected label B got %v", g.Node("b").Attrs)
+	t.Assert(g.Edges[1].From == g.Node("b"), "edges should share nodes")
+}
+
+func TestGraphModelNodeMerge(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `graph { a [color=red shape=box]; a [color=blue] }`)
+	a := g.Node("a")
+	t.Assert(len(g.Nodes) == 1, "expected 1 node got %v", len(g.Nodes))
+	t.Assert(a.Attrs["color"].Value == "blue", "expected blue got %v", a.Attrs["color"])
+	t.Assert(a.Attrs["shape"].Value == "box", "expected box got %v", a.Attrs["shape"])
+}
+
+func TestGraphModelDefaults(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `digraph {
+		a
+		node [shape=box]
+		edge [color=red]
+		b -> a
+		subgraph cluster_0 {
+			node [shape=circle]
+			c
+			a
+		}
+		d
+	}`)
+	t.Assert(g.Node("a").Attrs["shape"].Value == "", "a was declared before the default %v", g.Node("a").Attrs)
+	t.Assert(g.Node("b").Attrs["shape"].Value == "box", "expected box got %v", g.Node("b").Attrs)
+	t.Assert(g.Node("c").Attrs["shape"].Value == "circle", "expected circle got %v", g.Node("c").Attrs)
+	t.Assert(g.Node("d").Attrs["shape"].Value == "box", "subgraph defaults leaked %v", g.Node("d").Attrs)
+	t.Assert(g.Edges[0].Attrs["color"].Value == "red", "expected red got %v", g.Edges[0].Attrs)
+
+	sg := g.Subgraph("cluster_0")
+	t.Assert(sg != nil, "expected cluster_0")
+	t.Assert(sg.IsCluster(), "expected a cluster")
+	t.Assert(len(sg.Nodes) == 2, "expected 2 nodes got %v", len(sg.Nodes))
+	t.Assert(sg.Node("a") != nil, "a is mentioned in the cluster")
+	t.Assert(sg.Node("b") == nil, "b is not in the cluster")
+	t.Assert(sg.EdgeAttrs["color"].Value == "red", "subgraphs inherit edge defaults %v", sg.EdgeAttrs)
+	t.Assert(len(g.Clusters()) == 1, "expected 1 cluster got %v", len(g.Clusters()))
+}
+
+func TestGraphModelGraphAttrs(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `digraph { rankdir=LR; graph [label=x]; subgraph s { label=y } }`)
+	t.Assert(g.Attrs["rankdir"].Value == "LR", "expected LR got %v", g.Attrs)
+	t.Assert(g.Attrs["label"].Value == "x", "expected x got %v", g.Attrs)
+	sg := g.Subgraph("s")
+	t.Assert(sg.Attrs["label"].Value == "y", "expected y got %v", sg.Attrs)
+	t.Assert(sg.Attrs["rankdir"].Value == "LR", "expected inherited LR got %v", sg.Attrs)
+	t.Assert(!sg.IsCluster(), "s is not a cluster")
+}
+
+func TestGraphModelSubgraphEdges(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `digraph { a -> {b c} -> d:p:n [w=1] }`)
+	t.Assert(len(g.Nodes) == 4, "expected 4 nodes got %v", len(g.Nodes))
+	t.Assert(len(g.Edges) == 4, "expected 4 edges got %v", len(g.Edges))
+	t.Assert(len(g.Subgraphs) == 1, "the subgraph is shared by the chain %v", len(g.Subgraphs))
+	last := g.Edges[3]
+	t.Assert(last.From.Name == "c" && last.To.Name == "d", "bad edge %v", last)
+	t.Assert(last.ToPort == "p:n", "expected p:n got %v", last.ToPort)
+	t.Assert(last.Attrs["w"].Value == "1", "expected w=1 got %v", last.Attrs)
+}
+
+// The edges inside of a subgraph shared by a chain are only added once
+func TestGraphModelSharedSubgraphEdges(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `digraph { a -> {b -> c} -> d }`)
+	t.Assert(len(g.Edges) == 5, "expected 5 edges got %v", len(g.Edges))
+	sg := g.Subgraphs[0]
+	t.Assert(len(sg.Edges) == 1, "expected 1 edge in the subgraph got %v", len(sg.Edges))
+	inner := 0
+	for _, e := range g.Edges {
+		if e.From.Name == "b" && e.To.Name == "c" {
+			inner++
+		}
+	}
+	t.Assert(inner == 1, "expected the edge b -> c once got %v", inner)
+}
+
+func TestGraphModelStrictMerge(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `strict graph s {
+		edge [color=red]
+		a -- b [w=1, label=x]
+		edge [color=blue]
+		b -- a [w=2]
+		subgraph cluster_0 { b:p -- a [style=bold] }
+		a -- a
+		a -- a
+	}`)
+	t.Assert(len(g.Edges) == 2, "expected 2 edges got %v", len(g.Edges))
+	e := g.Edges[0]
+	for name, value := range map[string]string{"color": "red", "w": "2", "label": "x", "style": "bold"} {
+		t.Assert(e.Attrs[name].Value == value, "expected %v=%v got %v", name, value, e.Attrs)
+	}
+	t.Assert(e.ToPort == "p", "expected the port to be merged got %q", e.ToPort)
+	c := g.Subgraph("cluster_0")
+	t.Assert(len(c.Edges) == 1 && c.Edges[0] == e, "the merged edge should belong to the cluster %v", c.Edges)
+}
+
+func TestGraphModelStrictDirected(x *testing.T) {
+	t := (*test.T)(x)
+	g := parseGraph(t, `strict digraph { a -> b; b -> a; a -> b }`)
+	t.Assert(len(g.Edges) == 2, "expected 2 edges got %v", len(g.Edges))
+	g = parseGraph(t, `digraph { a -> b; a -> b }`)
+	t.Assert(len(g.Edges) == 2, "expected 2 edges in a non strict graph got %v", len(g.Edges))
+}
+
+func TestGraphModelStrictReport(x *testing.T) {
+	t := (*test.T)(x)
+	p := NewDotParser(nil)
+	p.Options.Strict = StrictReport
+	graphs, err := p.ParseGraphs([]byte(`strict digraph s {
+		a -> b [w=1]
+		a -> b [w=2]
+	}`))
+	t.AssertNil(err)
+	e := graphs[0].Edges
+	t.Assert(len(e) == 1 && e[0].Attrs["w"].Value == "1", "expected the first edge alone got %v", e)
+	t.Assert(len(p.Diagnostics) == 1, "expected 1 diagnostic got %v", p.Diagnostics)
+	d := p.Diagnostics[0]
+	t.Assert(d.Location.StartLine == 3, "expected line 3 got %v", d)
+	t.Log(d)
+}
@@ -1,11 +1,16 @@
 package dot
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 import (
+	"github.com/timtadh/combos"
+	"github.com/timtadh/dot/ast"
 	lex "github.com/timtadh/lexmachine"
 	"github.com/timtadh/lexmachine/machines"
 )
@@ -16,6 +21,34 @@ var Tokens []string         // All of the tokens (including literals and keyword
 var TokenIds map[string]int // A map from the token names to their int ids
 var Lexer *lex.Lexer        // The lexer object. Use this to construct a Scanner
 
+// The value of ID tokens and of the ID nodes of the tree returned by Parse.
+// Value has the quotes or angle brackets removed and, for quoted strings, the
+// escapes decoded (see unquote). Raw is the ID as it was written. Kind
+// records which form the ID was written in. The IDs of anonymous graphs and
+// subgraphs are named by the parser, see DotParser.NextName, and are marked
+// Anonymous.
+type ID struct {
+	Kind      ast.IDKind
+	Value     string
+	Raw       string
+	Anonymous bool
+}
+
+func (id ID) String() string {
+	return id.Value
+}
+
+// The string value of an ID node
+func idValue(n *combos.Node) string {
+	switch v := n.Value.(type) {
+	case ID:
+		return v.Value
+	case string:
+		return v
+	}
+	return ""
+}
+
 // Called at package initialization. Creates the lexer and populates token lists.
 func init() {
 	initTokens()
@@ -38,6 +71,7 @@ func initTokens() {
 		":",
 		"->",
 		"--",
+		"+",
 	}
 	Keywords = []string{
 		"NODE",
@@ -59,28 +93,128 @@ func initTokens() {
 	}
 }
 
-// Creates the lexer object and compiles the NFA.
+// Creates the lexer object and compiles the NFA. The lexer may be shared by
+// any number of scans so it has no runeCounter.
 func initLexer() (*lex.Lexer, error) {
+	return newLexer(nil, nil)
+}
+
+// Lexers are costly to compile so the lexers of the parsers are reused. A
+// lexer is only used by one parse at a time.
+var lexers = sync.Pool{
+	New: func() interface{} {
+		adj := new(adjuster)
+		cols := new(runeCounter)
+		lexer, err := newLexer(adj, cols)
+		if err != nil {
+			panic(err)
+		}
+		return &mappedLexer{Lexer: lexer, adj: adj, cols: cols}
+	},
+}
+
+// A lexer whose tokens have their positions mapped by adj and their columns
+// counted by cols
+type mappedLexer struct {
+	*lex.Lexer
+	adj  *adjuster
+	cols *runeCounter
+}
+
+// A lexer for a single parse, return it with putLexer once done.
+func getLexer() *mappedLexer {
+	return lexers.Get().(*mappedLexer)
+}
+
+func putLexer(l *mappedLexer) {
+	*l.adj = adjuster{}
+	*l.cols = runeCounter{} // the text is not kept alive by the pool
+	lexers.Put(l)
+}
+
+// Scan text as it is, without mapping the positions of its tokens
+func (l *mappedLexer) Scanner(text []byte) (*lex.Scanner, error) {
+	*l.adj = adjuster{line: 1, col: 1}
+	return l.Lexer.Scanner(text)
+}
+
+// Creates a lexer whose tokens have their positions mapped by adj and their
+// columns counted by cols. adj may be nil in which case positions are those
+// of the scanned text, cols may be nil in which case each column is counted
+// from the start of its line.
+func newLexer(adj *adjuster, cols *runeCounter) (*lex.Lexer, error) {
 	lexer := lex.NewLexer()
+	// a lex.Action function which constructs a Token of the given token
+	// type by the token type's name
+	token := func(name string) lex.Action {
+		return func(s *lex.Scanner, m *machines.Match) (interface{}, error) {
+			cols.runeColumns(s, m)
+			adj.match(m)
+			return s.Token(TokenIds[name], string(m.Bytes), m), nil
+		}
+	}
 
 	for _, lit := range Literals {
 		r := "\\" + strings.Join(strings.Split(lit, ""), "\\")
 		lexer.Add([]byte(r), token(lit))
 	}
+	// keywords are case-independent, the token keeps the spelling used
 	for _, name := range Keywords {
-		lexer.Add([]byte(strings.ToLower(name)), token(name))
+		lexer.Add([]byte(caseless(name)), token(name))
 	}
 
 	lexer.Add([]byte(`//[^\n]*\n?`), token("COMMENT"))
+	// lines starting with # are output by the C preprocessor and are
+	// discarded, see LineMap
+	lexer.Add([]byte(`#[^\n]*`),
+		func(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
+			cols.runeColumns(scan, match)
+			adj.match(match)
+			if match.StartColumn == 1 {
+				return nil, nil
+			}
+			return nil, &lexError{
+				msg: fmt.Sprintf("'#' at %d, (%d, %d) does not begin a line",
+					match.TC, match.StartLine, match.StartColumn),
+				at: matchLocation(match),
+			}
+		})
 	lexer.Add([]byte(`/\*([^*]|\r|\n|(\*+([^*/]|\r|\n)))*\*+/`), token("COMMENT"))
-	lexer.Add([]byte(`([a-z]|[A-Z]|[0-9]|_)+`), token("ID"))
-	lexer.Add([]byte(`[0-9]*\.[0-9]+`), token("ID"))
-	lexer.Add([]byte(`"([^\\"]|(\\.))*"`),
+	id := func(kind ast.IDKind) lex.Action {
+		return func(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
+			x, _ := token("ID")(scan, match)
+			t := x.(*lex.Token)
+			v := t.Value.(string)
+			t.Value = ID{Kind: kind, Value: v, Raw: v}
+			return t, nil
+		}
+	}
+	// any byte in \200-\377 is a letter, so UTF-8 names need no quoting
+	letter := "([a-z]|[A-Z]|_|[\x80-\xff])"
+	name := letter + "(" + letter + "|[0-9])*"
+	numeral := `-?(\.[0-9]+|[0-9]+(\.[0-9]*)?)`
+	lexer.Add([]byte(name), id(ast.Bare))
+	lexer.Add([]byte(numeral), id(ast.Numeral))
+	// graphviz splits 2abc into a numeral and a name, which is never what
+	// was meant
+	lexer.Add([]byte(numeral+name),
+		func(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
+			atEnd := scan.TC+len(match.Bytes) >= len(scan.Text)
+			cols.runeColumns(scan, match)
+			adj.match(match)
+			return nil, &lexError{
+				msg: fmt.Sprintf("ID %q starting at %d, (%d, %d) begins with a number but is not a numeral",
+					string(match.Bytes), match.TC, match.StartLine, match.StartColumn),
+				atEnd: atEnd,
+				at:    matchLocation(match),
+			}
+		})
+	lexer.Add([]byte(`"([^\\"]|(\\(.|\r|\n)))*"`),
 		func(scan *lex.Scanner, match *machines.Match) (interface{}, error) {
 			x, _ := token("ID")(scan, match)
 			t := x.(*lex.Token)
 			v := t.Value.(string)
-			t.Value = v[1 : len(v)-1]
+			t.Value = ID{Kind: ast.Quoted, Value: unquote(v[1 : len(v)-1]), Raw: v}
 			return t, nil
 		})
 	lexer.Add([]byte("( |\t|\n|\r)+"), skip)
@@ -109,13 +243,18 @@ func initLexer() (*lex.Lexer, error) {
 					x, _ := token("ID")(scan, match)
 					t := x.(*lex.Token)
 					v := t.Value.(string)
-					t.Value = v[1 : len(v)-1]
+					t.Value = ID{Kind: ast.HTML, Value: v[1 : len(v)-1], Raw: v}
 					return t, nil
 				}
 			}
-			return nil,
-				fmt.Errorf("unclosed HTML literal starting at %d, (%d, %d)",
-					match.TC, match.StartLine, match.StartColumn)
+			cols.runeColumns(scan, match)
+			adj.match(match)
+			return nil, &lexError{
+				msg: fmt.Sprintf("unclosed HTML literal starting at %d, (%d, %d)",
+					match.TC, match.StartLine, match.StartColumn),
+				atEnd: true,
+				at:    matchLocation(match),
+			}
 		},
 	)
 
@@ -126,15 +265,174 @@ func initLexer() (*lex.Lexer, error) {
 	return lexer, nil
 }
 
+// A pattern matching word in any case, eg. caseless("node") is
+// `[nN][oO][dD][eE]`.
+func caseless(word string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(word) {
+		b.WriteString("[" + string(r) + strings.ToUpper(string(r)) + "]")
+	}
+	return b.String()
+}
+
+// Decode the body of a quoted string. An escaped quote is unescaped and a
+// backslash followed by a newline joins the two lines. Every other escape,
+// including the escString sequences such as \N, \G and \l, is kept as it is
+// for the consumer of the attribute to interpret.
+func unquote(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b = append(b, s[i])
+			continue
+		}
+		switch {
+		case s[i+1] == '"':
+			b = append(b, '"')
+		case s[i+1] == '\n':
+		case s[i+1] == '\r' && i+2 < len(s) && s[i+2] == '\n':
+			i++
+		default:
+			b = append(b, s[i], s[i+1])
+		}
+		i++
+	}
+	return string(b)
+}
+
+// An error raised by a lexer action
+type lexError struct {
+	msg string
+	// whether the match ran into the end of the text, in which case more
+	// text might have allowed it to succeed
+	atEnd bool
+	at    *combos.Location // the text that could not be lexed
+}
+
+func (e *lexError) Error() string {
+	return e.msg
+}
+
+func matchLocation(m *machines.Match) *combos.Location {
+	return &combos.Location{
+		StartTC:     m.TC,
+		EndTC:       m.TC + len(m.Bytes),
+		StartLine:   m.StartLine,
+		StartColumn: m.StartColumn,
+		EndLine:     m.EndLine,
+		EndColumn:   m.EndColumn,
+	}
+}
+
 // a lex.Action function which skips the match.
 func skip(*lex.Scanner, *machines.Match) (interface{}, error) {
 	return nil, nil
 }
 
-// a lex.Action function with constructs a Token of the given token type by
-// the token type's name.
-func token(name string) lex.Action {
-	return func(s *lex.Scanner, m *machines.Match) (interface{}, error) {
-		return s.Token(TokenIds[name], string(m.Bytes), m), nil
+// The lexer counts columns in bytes. Columns are reported in runes so they
+// point at the same character an editor would whatever the encoding of the
+// characters before it.
+func (r *runeCounter) runeColumns(s *lex.Scanner, m *machines.Match) {
+	m.StartColumn = r.column(s.Text, m.TC, m.StartColumn)
+	if m.EndLine == m.StartLine {
+		m.EndColumn = m.StartColumn + utf8.RuneCount(m.Bytes) - 1
+	} else if len(m.Bytes) > 0 {
+		i := bytes.LastIndexByte(m.Bytes[:len(m.Bytes)-1], '\n')
+		m.EndColumn = utf8.RuneCount(m.Bytes[i+1:])
+	}
+}
+
+// A runeCounter converts byte columns into rune columns. Tokens are lexed in
+// order so it remembers how far along the current line it has counted,
+// keeping the conversion linear in the length of the line. Each lexer has a
+// counter of its own as it follows a single scan.
+type runeCounter struct {
+	text  []byte // the text last counted
+	line  int    // offset of the start of the line last counted
+	tc    int    // offset counted up to
+	runes int    // runes in text[line:tc]
+}
+
+// The rune column of offset tc of text which is at byte column col. Safe to
+// call on a nil runeCounter, which counts from the start of the line.
+func (r *runeCounter) column(text []byte, tc, col int) int {
+	line := tc - (col - 1)
+	if line < 0 || tc > len(text) {
+		return col
+	}
+	if r == nil {
+		return utf8.RuneCount(text[line:tc]) + 1
+	}
+	if !sameText(r.text, text) || r.line != line || r.tc > tc {
+		r.text, r.line, r.tc, r.runes = text, line, line, 0
+	}
+	for ; r.tc < tc; r.tc++ {
+		if utf8.RuneStart(text[r.tc]) {
+			r.runes++
+		}
+	}
+	return r.runes + 1
+}
+
+func sameText(a, b []byte) bool {
+	return len(a) == len(b) && len(a) > 0 && &a[0] == &b[0]
+}
+
+// An adjuster maps the positions of a scan over a window of some larger text
+// back to positions in the larger text. The first start bytes of the scanned
+// text are not part of the window, they are synthesized by the caller and
+// are mapped to the start of the window.
+type adjuster struct {
+	start int // length of the synthesized prefix of the scanned text
+	tc    int // offset of the window in the larger text
+	line  int // line of the window in the larger text
+	col   int // column of the window in the larger text
+}
+
+// Map the position of a match. Safe to call on a nil adjuster.
+func (a *adjuster) match(m *machines.Match) {
+	if a == nil {
+		return
+	}
+	m.TC = a.offset(m.TC)
+	m.StartLine, m.StartColumn = a.position(m.StartLine, m.StartColumn)
+	m.EndLine, m.EndColumn = a.position(m.EndLine, m.EndColumn)
+}
+
+func (a *adjuster) offset(tc int) int {
+	if tc < a.start {
+		return a.tc
+	}
+	return a.tc + tc - a.start
+}
+
+// The synthesized prefix never contains a newline so it only shifts the
+// columns of the first line.
+func (a *adjuster) position(line, col int) (int, int) {
+	if line <= 1 {
+		col -= a.start
+		if col < 1 {
+			col = 1
+		}
+		return a.line, a.col + col - 1
+	}
+	return a.line + line - 1, col
+}
+
+// Map the positions of an error returned by a scanner. Errors are rare so
+// their columns are counted from the start of their lines.
+func (a *adjuster) error(err error) error {
+	if u, is := err.(*machines.UnconsumedInput); is {
+		var cols *runeCounter
+		u.StartColumn = cols.column(u.Text, u.StartTC, u.StartColumn)
+		u.FailColumn = cols.column(u.Text, u.FailTC, u.FailColumn)
+		u.StartTC = a.offset(u.StartTC)
+		u.FailTC = a.offset(u.FailTC)
+		u.StartLine, u.StartColumn = a.position(u.StartLine, u.StartColumn)
+		u.FailLine, u.FailColumn = a.position(u.FailLine, u.FailColumn)
 	}
+	return err
 }
@@ -9,6 +9,7 @@ import (
 )
 
 import (
+	"github.com/timtadh/dot/ast"
 	lex "github.com/timtadh/lexmachine"
 )
 
@@ -106,7 +107,13 @@ func TestKeywords(x *testing.T) {
 	t := (*test.T)(x)
 	for _, keyword := range Keywords {
 		match(t, strings.ToLower(keyword), keyword)
+		match(t, keyword, keyword)
+		match(t, strings.ToUpper(keyword[:1])+strings.ToLower(keyword[1:]), keyword)
 	}
+	match(t, "DiGraph", "DIGRAPH")
+	match(t, "sUbGrApH", "SUBGRAPH")
+	match(t, "nodes", "ID")
+	match(t, "Digraph2", "ID")
 }
 
 func TestLineComment1(x *testing.T) {
@@ -163,3 +170,153 @@ func TestWhite(x *testing.T) {
 	whitespace(t, "\r")
 	whitespace(t, "\r  \r\t \n")
 }
+
+func matchID(t *test.T, text string, kind ast.IDKind, value string) {
+	s, err := Lexer.Scanner([]byte(text))
+	t.AssertNil(err)
+	tok, err, eof := s.Next()
+	t.AssertNil(err)
+	t.Assert(!eof, "got eof")
+	token := tok.(*lex.Token)
+	t.Assert(token.Type == TokenIds["ID"], "expected an ID got %v", Tokens[token.Type])
+	id, ok := token.Value.(ID)
+	t.Assert(ok, "expected an ID value got %T", token.Value)
+	t.Assert(id.Kind == kind, "%v: expected %v got %v", text, kind, id.Kind)
+	t.Assert(id.Value == value, "%v: expected %q got %q", text, value, id.Value)
+}
+
+func TestIDKinds(x *testing.T) {
+	t := (*test.T)(x)
+	matchID(t, "abc_1", ast.Bare, "abc_1")
+	matchID(t, "123", ast.Numeral, "123")
+	matchID(t, ".5", ast.Numeral, ".5")
+	matchID(t, "12.5", ast.Numeral, "12.5")
+	matchID(t, `"<b>"`, ast.Quoted, "<b>")
+	matchID(t, `<<b>>`, ast.HTML, "<b>")
+}
+
+func TestUnquote(x *testing.T) {
+	t := (*test.T)(x)
+	cases := map[string]string{
+		`plain`:              `plain`,
+		`say \"hi\"`:         `say "hi"`,
+		"long \\\nline":      "long line",
+		"long \\\r\nline":    "long line",
+		`\N at \G\l`:         `\N at \G\l`,
+		`back\\slash`:        `back\\slash`,
+		`\\\"`:               `\\"`,
+		"two\\\nbreaks\\\n.": "twobreaks.",
+	}
+	for raw, expected := range cases {
+		got := unquote(raw)
+		t.Assert(got == expected, "unquote(%q) expected %q got %q", raw, expected, got)
+	}
+}
+
+func TestQuotedIDValue(x *testing.T) {
+	t := (*test.T)(x)
+	text := "\"a \\\"b\\\" \\\nc\\l\""
+	matchID(t, text, ast.Quoted, "a \"b\" c\\l")
+	s, err := Lexer.Scanner([]byte(text))
+	t.AssertNil(err)
+	tok, err, _ := s.Next()
+	t.AssertNil(err)
+	id := tok.(*lex.Token).Value.(ID)
+	t.Assert(id.Raw == text, "expected raw %q got %q", text, id.Raw)
+}
+
+func TestNumerals(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{"1", "-1", "1.", "-1.", ".5", "-.5", "1.25", "-12.5", "007"} {
+		match(t, text, "ID")
+		matchID(t, text, ast.Numeral, text)
+	}
+}
+
+func TestNotNumerals(x *testing.T) {
+	t := (*test.T)(x)
+	not_match(t, 0, "12abc", "ID")
+	not_match(t, 0, "1.5e3", "ID")
+	not_match(t, 0, "-1_x", "ID")
+	not_match(t, 0, "-", "ID")
+}
+
+func TestNumeralEdges(x *testing.T) {
+	t := (*test.T)(x)
+	s, err := Lexer.Scanner([]byte(`a--1 b->-.5`))
+	t.AssertNil(err)
+	expected := []string{"ID", "--", "ID", "ID", "->", "ID"}
+	i := 0
+	for tok, err, eof := s.Next(); !eof; tok, err, eof = s.Next() {
+		t.AssertNil(err)
+		token := tok.(*lex.Token)
+		t.Assert(Tokens[token.Type] == expected[i], "expected %v got %v", expected[i], Tokens[token.Type])
+		i++
+	}
+	t.Assert(i == len(expected), "expected %v tokens got %v", len(expected), i)
+}
+
+func TestUTF8IDs(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{"日本語", "Größe", "über_1", "_ß", "é2"} {
+		match(t, text, "ID")
+		matchID(t, text, ast.Bare, text)
+	}
+	not_match(t, 0, "2ß", "ID")
+}
+
+func TestRuneColumns(x *testing.T) {
+	t := (*test.T)(x)
+	s, err := Lexer.Scanner([]byte("日本 -> Größe [label=\"ü\"]\n  é -- b"))
+	t.AssertNil(err)
+	expected := []struct {
+		lexeme     string
+		line       int
+		start, end int
+	}{
+		{"日本", 1, 1, 2},
+		{"->", 1, 4, 5},
+		{"Größe", 1, 7, 11},
+		{"[", 1, 13, 13},
+		{"label", 1, 14, 18},
+		{"=", 1, 19, 19},
+		{`"ü"`, 1, 20, 22},
+		{"]", 1, 23, 23},
+		{"é", 2, 3, 3},
+		{"--", 2, 5, 6},
+		{"b", 2, 8, 8},
+	}
+	i := 0
+	for tok, err, eof := s.Next(); !eof; tok, err, eof = s.Next() {
+		t.AssertNil(err)
+		token := tok.(*lex.Token)
+		e := expected[i]
+		t.Assert(string(token.Lexeme) == e.lexeme, "expected %q got %q", e.lexeme, token.Lexeme)
+		t.Assert(token.StartLine == e.line, "%v: expected line %v got %v", e.lexeme, e.line, token.StartLine)
+		t.Assert(token.StartColumn == e.start, "%v: expected column %v got %v", e.lexeme, e.start, token.StartColumn)
+		t.Assert(token.EndColumn == e.end, "%v: expected end column %v got %v", e.lexeme, e.end, token.EndColumn)
+		i++
+	}
+	t.Assert(i == len(expected), "expected %v tokens got %v", len(expected), i)
+}
+
+func TestKeywordLexeme(x *testing.T) {
+	t := (*test.T)(x)
+	s, err := Lexer.Scanner([]byte("Strict DiGraph"))
+	t.AssertNil(err)
+	for _, e := range []struct{ lexeme, kind string }{{"Strict", "STRICT"}, {"DiGraph", "DIGRAPH"}} {
+		tok, err, eof := s.Next()
+		t.AssertNil(err)
+		t.Assert(!eof, "got eof")
+		token := tok.(*lex.Token)
+		t.Assert(Tokens[token.Type] == e.kind, "expected %v got %v", e.kind, Tokens[token.Type])
+		t.Assert(string(token.Lexeme) == e.lexeme, "expected %q got %q", e.lexeme, token.Lexeme)
+	}
+}
+
+func TestPreprocessorLines(x *testing.T) {
+	t := (*test.T)(x)
+	whitespace(t, "# 1 \"graph.dot\"")
+	whitespace(t, "#pragma once\n# 2\n")
+	not_match(t, 1, "a # 1", "ID")
+}
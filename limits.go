@@ -0,0 +1,291 @@
+package dot
+
+import (
+	"fmt"
+	"io"
+)
+
+import (
+	"github.com/timtadh/combos"
+	lex "github.com/timtadh/lexmachine"
+)
+
+// An InputSizeError is returned when the input is longer than
+// ParseOptions.MaxBytes.
+type InputSizeError struct {
+	Limit int
+}
+
+func (e *InputSizeError) Error() string {
+	return fmt.Sprintf("input is longer than %d bytes", e.Limit)
+}
+
+// A DepthError is returned when subgraphs are nested deeper than
+// ParseOptions.MaxDepth. The position is that of the `{` opening the
+// subgraph too deep.
+type DepthError struct {
+	Limit  int
+	Line   int
+	Column int
+}
+
+func (e *DepthError) Error() string {
+	msg := fmt.Sprintf("subgraphs are nested deeper than %d", e.Limit)
+	if e.Line == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%d:%d: %v", e.Line, e.Column, msg)
+}
+
+// A StmtCountError is returned when the input has more statements than
+// ParseOptions.MaxStmts. The position is that of the first statement over
+// the limit.
+type StmtCountError struct {
+	Limit  int
+	Line   int
+	Column int
+}
+
+func (e *StmtCountError) Error() string {
+	msg := fmt.Sprintf("more than %d statements", e.Limit)
+	if e.Line == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%d:%d: %v", e.Line, e.Column, msg)
+}
+
+// An IDLengthError is returned when an ID is longer than
+// ParseOptions.MaxIDLength.
+type IDLengthError struct {
+	Limit  int
+	Length int // of the ID in bytes
+	Line   int
+	Column int
+}
+
+func (e *IDLengthError) Error() string {
+	msg := fmt.Sprintf("ID of %d bytes is longer than %d", e.Length, e.Limit)
+	if e.Line == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%d:%d: %v", e.Line, e.Column, msg)
+}
+
+// A ChainLengthError is returned when an edge chain has more edges than
+// ParseOptions.MaxChain. The position is that of the edge operator of the
+// first edge over the limit.
+type ChainLengthError struct {
+	Limit  int
+	Line   int
+	Column int
+}
+
+func (e *ChainLengthError) Error() string {
+	msg := fmt.Sprintf("edge chain of more than %d edges", e.Limit)
+	if e.Line == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%d:%d: %v", e.Line, e.Column, msg)
+}
+
+// An AttrCountError is returned when an attribute list has more attributes
+// than ParseOptions.MaxAttrs. Lists which follow one another, `[a=1][b=2]`,
+// count as one. The position is that of the `=` of the first attribute over
+// the limit.
+type AttrCountError struct {
+	Limit  int
+	Line   int
+	Column int
+}
+
+func (e *AttrCountError) Error() string {
+	msg := fmt.Sprintf("attribute list of more than %d attributes", e.Limit)
+	if e.Line == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%d:%d: %v", e.Line, e.Column, msg)
+}
+
+// The counts the limits of a parse are checked against. The first limit
+// exceeded, or the error of the context, is kept in err and fails the parse
+// in place of the syntax error reported by the grammar.
+type limits struct {
+	stmts int
+	err   error
+}
+
+// Fail the parse at n with err, every later check fails with the same error
+// so that the grammar cannot backtrack into a parse which succeeds.
+func (d *DotParser) stop(n *combos.Node, err error) *combos.ParseError {
+	if d.limits.err == nil {
+		d.limits.err = err
+	}
+	return n.Error("Parse stopped").Chain(d.limits.err)
+}
+
+func position(n *combos.Node) (line, column int) {
+	if l := n.Location(); l != nil {
+		return l.StartLine, l.StartColumn
+	}
+	return 0, 0
+}
+
+// Check the context and the statement count before statement n is delivered
+func (d *DotParser) checkStmt(n *combos.Node) *combos.ParseError {
+	if d.limits.err != nil {
+		return d.stop(n, d.limits.err)
+	}
+	if ctx := d.Options.Context; ctx != nil && ctx.Err() != nil {
+		return d.stop(n, ctx.Err())
+	}
+	d.limits.stmts++
+	if max := d.Options.MaxStmts; max > 0 && d.limits.stmts > max {
+		line, col := position(n)
+		return d.stop(n, &StmtCountError{Limit: max, Line: line, Column: col})
+	}
+	return nil
+}
+
+// Check the nesting of the subgraphs, the edge chains and the attribute
+// lists of text before it is parsed, see tokenCounts.
+func (d *DotParser) checkTokens(text []byte) error {
+	o := &d.Options
+	if o.MaxDepth <= 0 && o.MaxChain <= 0 && o.MaxAttrs <= 0 {
+		return nil
+	}
+	lexer := getLexer()
+	defer putLexer(lexer)
+	scan, err := lexer.Scanner(text)
+	if err != nil {
+		return nil
+	}
+	var counts tokenCounts
+	for tok, err, eof := scan.Next(); !eof; tok, err, eof = scan.Next() {
+		if err != nil {
+			// the parse fails on the same error
+			return nil
+		}
+		if err := counts.add(o, tok.(*lex.Token)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counts the nesting of subgraphs, the edges of chains and the attributes of
+// lists as the tokens of the input go by. The grammar recurses for each
+// level, edge and attribute so they are checked on the tokens, before the
+// grammar runs.
+type tokenCounts struct {
+	bodies []*bodyCounts // the bodies entered, that of the graph first
+	lists  int           // the unclosed `[`
+	attrs  int           // the attributes of the lists being read
+	last   string        // the type of the last token
+}
+
+// The tokens of a body
+type bodyCounts struct {
+	recent []*lex.Token // the last two tokens of the body, see startsStmt
+	chain  int          // the edges of the chain being read
+}
+
+func (c *tokenCounts) add(o *ParseOptions, tok *lex.Token) error {
+	name := Tokens[tok.Type]
+	if name == "COMMENT" {
+		return nil
+	}
+	defer func() {
+		c.last = name
+	}()
+	if name == "}" && len(c.bodies) > 0 {
+		// closes any unclosed `[` along with the body
+		c.bodies = c.bodies[:len(c.bodies)-1]
+		c.lists = 0
+	}
+	switch name {
+	case "[":
+		if c.lists == 0 && c.last != "]" {
+			c.attrs = 0
+		}
+		c.lists++
+	case "]":
+		if c.lists > 0 {
+			c.lists--
+		}
+	case "=":
+		if c.lists > 0 {
+			c.attrs++
+			if max := o.MaxAttrs; max > 0 && c.attrs > max {
+				return &AttrCountError{Limit: max, Line: tok.StartLine, Column: tok.StartColumn}
+			}
+		}
+	}
+	if len(c.bodies) > 0 && c.lists == 0 {
+		b := c.bodies[len(c.bodies)-1]
+		if name == "+" || (name == "ID" && c.last == "+") {
+			// IDs joined with + are recent as their first ID
+			return nil
+		}
+		if len(b.recent) > 0 && startsStmt(b.recent, name) {
+			b.chain = 0
+		}
+		switch name {
+		case "->", "--":
+			b.chain++
+			if max := o.MaxChain; max > 0 && b.chain > max {
+				return &ChainLengthError{Limit: max, Line: tok.StartLine, Column: tok.StartColumn}
+			}
+		case ";":
+			b.chain = 0
+			b.recent = b.recent[:0]
+			return nil
+		}
+		if len(b.recent) == 2 {
+			b.recent[0], b.recent[1] = b.recent[1], tok
+		} else {
+			b.recent = append(b.recent, tok)
+		}
+	}
+	if name == "{" {
+		c.bodies = append(c.bodies, new(bodyCounts))
+		// the body of the graph is the first
+		if max := o.MaxDepth; max > 0 && len(c.bodies)-1 > max {
+			return depthError(max, tok)
+		}
+	}
+	return nil
+}
+
+// A DepthError for the subgraph opened by brace
+func depthError(max int, brace *lex.Token) *DepthError {
+	return &DepthError{Limit: max, Line: brace.StartLine, Column: brace.StartColumn}
+}
+
+// Check the length of ID n
+func (d *DotParser) checkID(n *combos.Node) *combos.ParseError {
+	if d.limits.err != nil {
+		return d.stop(n, d.limits.err)
+	}
+	length := len(idValue(n))
+	if max := d.Options.MaxIDLength; max > 0 && length > max {
+		line, col := position(n)
+		return d.stop(n, &IDLengthError{Limit: max, Length: length, Line: line, Column: col})
+	}
+	return nil
+}
+
+// Fails once more than max bytes have been read
+type limitedReader struct {
+	r    io.Reader
+	max  int
+	read int
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += n
+	if l.read > l.max {
+		return 0, &InputSizeError{Limit: l.max}
+	}
+	return n, err
+}
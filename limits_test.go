@@ -0,0 +1,175 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"context"
+	"strings"
+)
+
+import (
+	. "github.com/timtadh/combos"
+)
+
+// Parse the text with the options both from memory and from a reader
+func parseLimited(t *test.T, opts ParseOptions, text string) []error {
+	d := NewDotParser(nil)
+	d.Options = opts
+	_, err := d.Parse([]byte(text))
+	r := NewDotParser(&stmtCollector{stmts: new([]*Node)})
+	r.Options = opts
+	return []error{err, r.ParseReader(strings.NewReader(text))}
+}
+
+func TestMaxBytes(x *testing.T) {
+	t := (*test.T)(x)
+	text := `digraph { a -> b }`
+	for _, err := range parseLimited(t, ParseOptions{MaxBytes: len(text) - 1}, text) {
+		e, ok := err.(*InputSizeError)
+		t.Assert(ok, "expected an InputSizeError got %v", err)
+		t.Assert(e.Limit == len(text)-1, "expected the limit got %v", e.Limit)
+	}
+	for _, err := range parseLimited(t, ParseOptions{MaxBytes: len(text)}, text) {
+		t.AssertNil(err)
+	}
+}
+
+func TestMaxDepth(x *testing.T) {
+	t := (*test.T)(x)
+	text := `digraph { subgraph a { subgraph b { c } } }`
+	for _, err := range parseLimited(t, ParseOptions{MaxDepth: 1}, text) {
+		e, ok := err.(*DepthError)
+		t.Assert(ok, "expected a DepthError got %v", err)
+		t.Assert(e.Line == 1 && e.Column == 35, "expected 1:35 got %d:%d", e.Line, e.Column)
+	}
+	for _, err := range parseLimited(t, ParseOptions{MaxDepth: 2}, text) {
+		t.AssertNil(err)
+	}
+	// the nesting is stopped before it can exhaust the stack
+	deep := "digraph { " + strings.Repeat("{ ", 10000) + strings.Repeat("} ", 10000) + "}"
+	for _, err := range parseLimited(t, ParseOptions{MaxDepth: 10}, deep) {
+		_, ok := err.(*DepthError)
+		t.Assert(ok, "expected a DepthError got %v", err)
+	}
+}
+
+func TestMaxStmts(x *testing.T) {
+	t := (*test.T)(x)
+	text := `digraph { a; b; c -> d -> e }`
+	for _, err := range parseLimited(t, ParseOptions{MaxStmts: 3}, text) {
+		e, ok := err.(*StmtCountError)
+		t.Assert(ok, "expected a StmtCountError got %v", err)
+		t.Assert(e.Limit == 3, "expected the limit got %v", e.Limit)
+	}
+	for _, err := range parseLimited(t, ParseOptions{MaxStmts: 4}, text) {
+		t.AssertNil(err)
+	}
+}
+
+func TestMaxIDLength(x *testing.T) {
+	t := (*test.T)(x)
+	for _, err := range parseLimited(t, ParseOptions{MaxIDLength: 5}, `digraph { a -> abcdef }`) {
+		e, ok := err.(*IDLengthError)
+		t.Assert(ok, "expected an IDLengthError got %v", err)
+		t.Assert(e.Length == 6, "expected length 6 got %v", e.Length)
+		t.Assert(e.Line == 1 && e.Column == 16, "expected 1:16 got %d:%d", e.Line, e.Column)
+	}
+	for _, err := range parseLimited(t, ParseOptions{MaxIDLength: 3}, `digraph { a [label="ab" + "cd"] }`) {
+		e, ok := err.(*IDLengthError)
+		t.Assert(ok, "expected an IDLengthError got %v", err)
+		t.Assert(e.Length == 4, "expected length 4 got %v", e.Length)
+	}
+	for _, err := range parseLimited(t, ParseOptions{MaxIDLength: 6}, `digraph { a -> abcdef }`) {
+		t.AssertNil(err)
+	}
+}
+
+// Cancels the context of the parse on the first statement
+type cancelCallbacks struct {
+	stmtCollector
+	cancel context.CancelFunc
+}
+
+func (c *cancelCallbacks) Stmt(n *Node) error {
+	c.cancel()
+	return c.stmtCollector.Stmt(n)
+}
+
+func TestMaxChain(x *testing.T) {
+	t := (*test.T)(x)
+	for _, err := range parseLimited(t, ParseOptions{MaxChain: 2}, `digraph { a -> b -> c -> d }`) {
+		e, ok := err.(*ChainLengthError)
+		t.Assert(ok, "expected a ChainLengthError got %v", err)
+		t.Assert(e.Line == 1 && e.Column == 23, "expected 1:23 got %d:%d", e.Line, e.Column)
+	}
+	for _, text := range []string{
+		`digraph { a -> b -> c; d -> e -> f }`,
+		`digraph { a -> b -> c d -> e -> f }`,
+		`digraph { a -> b [w=1] c -> d -> e }`,
+		`digraph { a:p:n -> {b -> c -> d} -> e }`,
+		`digraph { a -> subgraph s { b } -> e }`,
+	} {
+		for _, err := range parseLimited(t, ParseOptions{MaxChain: 2}, text) {
+			t.AssertNil(err)
+		}
+	}
+	// the chain is stopped before it can exhaust the stack
+	long := "digraph { a" + strings.Repeat(" -> a", 100000) + " }"
+	for _, err := range parseLimited(t, ParseOptions{MaxChain: 1000}, long) {
+		e, ok := err.(*ChainLengthError)
+		t.Assert(ok, "expected a ChainLengthError got %v", err)
+		t.Assert(e.Limit == 1000, "expected the limit got %v", e.Limit)
+	}
+}
+
+func TestMaxAttrs(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{
+		`digraph { a [x=1, y=2, z=3] }`,
+		`digraph { a [x=1][y=2; z=3] }`,
+		`digraph { edge [x=1 y=2 z=3] }`,
+	} {
+		for _, err := range parseLimited(t, ParseOptions{MaxAttrs: 2}, text) {
+			_, ok := err.(*AttrCountError)
+			t.Assert(ok, "%v: expected an AttrCountError got %v", text, err)
+		}
+	}
+	for _, text := range []string{
+		`digraph { a [x=1, y=2]; b [z=3] c [w=4 v=5] }`,
+		`digraph { a=1; b=2; c=3 }`,
+	} {
+		for _, err := range parseLimited(t, ParseOptions{MaxAttrs: 2}, text) {
+			t.AssertNil(err)
+		}
+	}
+	long := "digraph { a [" + strings.Repeat("x=1 ", 100000) + "] }"
+	for _, err := range parseLimited(t, ParseOptions{MaxAttrs: 1000}, long) {
+		_, ok := err.(*AttrCountError)
+		t.Assert(ok, "expected an AttrCountError got %v", err)
+	}
+}
+
+func TestParseContext(x *testing.T) {
+	t := (*test.T)(x)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range parseLimited(t, ParseOptions{Context: ctx}, `digraph { a }`) {
+		t.Assert(err == context.Canceled, "expected the parse to be canceled got %v", err)
+	}
+	text := `digraph { a; b; c }`
+	for _, stream := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var stmts []*Node
+		d := NewDotParser(&cancelCallbacks{stmtCollector{stmts: &stmts}, cancel})
+		d.Options.Context = ctx
+		var err error
+		if stream {
+			err = d.ParseReader(strings.NewReader(text))
+		} else {
+			_, err = d.Parse([]byte(text))
+		}
+		t.Assert(err == context.Canceled, "expected the parse to be canceled got %v", err)
+		t.Assert(len(stmts) == 1, "expected 1 statement got %v", len(stmts))
+	}
+}
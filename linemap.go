@@ -0,0 +1,157 @@
+package dot
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+import (
+	lex "github.com/timtadh/lexmachine"
+)
+
+// A LineMap maps the lines of a file produced by the C preprocessor back to
+// the lines of the files it was produced from. The preprocessor marks where
+// the lines came from with lines of the form
+//
+//	# 12 "graph.dot"
+//	#line 12 "graph.dot"
+//
+// stating that the following line is line 12 of graph.dot. The file name may
+// be omitted in which case the file is unchanged.
+type LineMap struct {
+	markers []lineMarker
+}
+
+type lineMarker struct {
+	line int    // the line of the marker in the preprocessed text
+	file string // the file of the lines following the marker
+	orig int    // the line in file of the line following the marker
+}
+
+// Find the line markers in text. Only the `#` lines skipped by the lexer
+// are markers, a line of a quoted string or a comment starting with `#` is
+// not.
+func NewLineMap(text []byte) *LineMap {
+	m := new(LineMap)
+	lexer := getLexer()
+	defer putLexer(lexer)
+	scan, err := lexer.Scanner(text)
+	if err != nil {
+		return m
+	}
+	var tokens []*lex.Token
+	for tok, err, eof := scan.Next(); !eof; tok, err, eof = scan.Next() {
+		if err != nil {
+			// the parse fails on the same error, the lines after it are
+			// never reported
+			break
+		}
+		tokens = append(tokens, tok.(*lex.Token))
+	}
+	s := markerScan{m: m, line: 1, bol: true}
+	at := s.tokens(text, 0, tokens)
+	s.gap(text[at:])
+	return m
+}
+
+// Finds the line markers in the text the lexer skips between tokens
+type markerScan struct {
+	m    *LineMap
+	line int  // the line of the text scanned next
+	bol  bool // whether the text scanned next begins a line
+}
+
+// Scan the text before each of the tokens lexed from text, which starts at
+// offset base of the input, returning the end of the last token in text.
+func (s *markerScan) tokens(text []byte, base int, tokens []*lex.Token) int {
+	at := 0
+	for _, tok := range tokens {
+		start := tok.TC - base
+		s.gap(text[at:start])
+		s.line += bytes.Count(tok.Lexeme, []byte("\n"))
+		s.bol = bytes.HasSuffix(tok.Lexeme, []byte("\n"))
+		at = start + len(tok.Lexeme)
+	}
+	return at
+}
+
+// Scan text skipped by the lexer
+func (s *markerScan) gap(text []byte) {
+	for len(text) > 0 {
+		end := bytes.IndexByte(text, '\n')
+		if end < 0 {
+			end = len(text)
+		}
+		if s.bol && text[0] == '#' {
+			s.m.marker(s.line, text[:end])
+		}
+		if end == len(text) {
+			s.bol = false
+			return
+		}
+		text = text[end+1:]
+		s.line++
+		s.bol = true
+	}
+}
+
+// Record the `#` line at line if it is a line marker. Lines must be given in
+// increasing order.
+func (m *LineMap) marker(line int, text []byte) {
+	s := strings.TrimSpace(strings.TrimPrefix(string(text), "#"))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "line"))
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	orig, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return
+	}
+	file := ""
+	if len(m.markers) > 0 {
+		file = m.markers[len(m.markers)-1].file
+	}
+	if rest := strings.TrimSpace(s[i:]); strings.HasPrefix(rest, `"`) {
+		if end := closingQuote(rest); end > 0 {
+			file = unquote(rest[1:end])
+		}
+	}
+	m.markers = append(m.markers, lineMarker{line: line, file: file, orig: orig})
+}
+
+// The index of the quote closing the string starting at s[0] or -1
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// The file and line in it of a line of the preprocessed text. The file is
+// empty for lines before the first marker or markers without a file name.
+func (m *LineMap) Position(line int) (string, int) {
+	if m == nil {
+		return "", line
+	}
+	lo, hi := 0, len(m.markers)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if m.markers[mid].line < line {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return "", line
+	}
+	mk := m.markers[lo-1]
+	return mk.file, mk.orig + line - mk.line - 1
+}
@@ -0,0 +1,84 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"bytes"
+)
+
+func TestLineMap(x *testing.T) {
+	t := (*test.T)(x)
+	m := NewLineMap([]byte("a\n# 10 \"x.dot\"\nb\nc\n#line 3\nd\n# 7 \"y \\\"z\\\".dot\" 1 3\ne\n#pragma x\nf\n"))
+	for _, e := range []struct {
+		line int
+		file string
+		orig int
+	}{
+		{1, "", 1},
+		{3, "x.dot", 10},
+		{4, "x.dot", 11},
+		{6, "x.dot", 3},
+		{8, `y "z".dot`, 7},
+		{10, `y "z".dot`, 9},
+	} {
+		file, line := m.Position(e.line)
+		t.Assert(file == e.file && line == e.orig, "line %v: expected %v:%v got %v:%v",
+			e.line, e.file, e.orig, file, line)
+	}
+}
+
+func TestLineMarkerDiagnostics(x *testing.T) {
+	t := (*test.T)(x)
+	text := "# 1 \"a.dot\"\ndigraph {\n# 40 \"b.dot\"\n  a -- b\n}\n"
+	p := NewDotParser(nil)
+	p.Options = ParseOptions{Lenient: true, LineMarkers: true}
+	_, err := p.Parse([]byte(text))
+	t.AssertNil(err)
+	t.Assert(len(p.Diagnostics) == 1, "expected a diagnostic got %v", p.Diagnostics)
+	d := p.Diagnostics[0]
+	t.Assert(d.File == "b.dot" && d.Location.StartLine == 40, "expected b.dot:40 got %v", d)
+
+	r := NewDotParser(nil)
+	r.Options = ParseOptions{Lenient: true, LineMarkers: true}
+	t.AssertNil(r.ParseReader(bytes.NewReader([]byte(text))))
+	t.Assert(len(r.Diagnostics) == 1, "expected a diagnostic got %v", r.Diagnostics)
+	d = r.Diagnostics[0]
+	t.Assert(d.File == "b.dot" && d.Location.StartLine == 40, "expected b.dot:40 got %v", d)
+}
+
+func TestLineMarkerErrors(x *testing.T) {
+	t := (*test.T)(x)
+	p := NewDotParser(nil)
+	p.Options.LineMarkers = true
+	_, err := p.Parse([]byte("# 5 \"g.dot\"\ndigraph {\n  a -> }\n"))
+	t.Assert(err != nil, "expected an error")
+	e, ok := err.(*SyntaxError)
+	t.Assert(ok, "expected a SyntaxError got %T", err)
+	t.Assert(e.File == "g.dot" && e.Line == 6, "expected g.dot:6 got %v", e)
+	t.Assert(bytes.HasPrefix([]byte(err.Error()), []byte("g.dot:6:")), "expected the file in %v", err)
+}
+
+// A line of a quoted string or a comment starting with # is not a marker
+func TestLineMarkersInStrings(x *testing.T) {
+	t := (*test.T)(x)
+	text := "# 1 \"a.dot\"\ndigraph {\n  a [label=\"x\n# 90 \\\"q.dot\\\"\n\"]\n  /*\n# 80\n  */\n  a -- b\n}\n"
+	m := NewLineMap([]byte(text))
+	file, line := m.Position(9)
+	t.Assert(file == "a.dot" && line == 8, "expected a.dot:8 got %v:%v", file, line)
+
+	p := NewDotParser(nil)
+	p.Options = ParseOptions{Lenient: true, LineMarkers: true}
+	_, err := p.Parse([]byte(text))
+	t.AssertNil(err)
+	t.Assert(len(p.Diagnostics) == 1, "expected a diagnostic got %v", p.Diagnostics)
+	d := p.Diagnostics[0]
+	t.Assert(d.File == "a.dot" && d.Location.StartLine == 8, "expected a.dot:8 got %v", d)
+
+	r := NewDotParser(nil)
+	r.Options = ParseOptions{Lenient: true, LineMarkers: true}
+	t.AssertNil(r.ParseReader(bytes.NewReader([]byte(text))))
+	t.Assert(len(r.Diagnostics) == 1, "expected a diagnostic got %v", r.Diagnostics)
+	d = r.Diagnostics[0]
+	t.Assert(d.File == "a.dot" && d.Location.StartLine == 8, "expected a.dot:8 got %v", d)
+}
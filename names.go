@@ -0,0 +1,129 @@
+package dot
+
+import (
+	"fmt"
+)
+
+import (
+	lex "github.com/timtadh/lexmachine"
+)
+
+// A Namer proposes names for the anonymous graphs and subgraphs of the
+// input. Name is called with kind "graph" or "subgraph" and n counting the
+// names proposed for that kind from 1. A name which is an ID of the input,
+// or was given to an earlier anonymous graph, is rejected and Name is called
+// again with the next n, so Name must give distinct names for distinct n.
+type Namer interface {
+	Name(kind string, n int) string
+}
+
+// A function proposing names, see Namer
+type NamerFunc func(kind string, n int) string
+
+func (f NamerFunc) Name(kind string, n int) string {
+	return f(kind, n)
+}
+
+// The Namer used when ParseOptions.Namer is nil. The graphs and subgraphs
+// are numbered separately: graph1, graph2, subgraph1, ...
+var DefaultNamer Namer = NamerFunc(func(kind string, n int) string {
+	return fmt.Sprintf("%v%d", kind, n)
+})
+
+// The names in use in a parse
+type names struct {
+	source []byte          // the text to take the IDs from, see declared
+	taken  map[string]bool // the IDs of the input and the names given
+	counts map[string]int  // the names proposed of each kind
+	ids    idSet
+}
+
+// Start naming the anonymous graphs of source. The IDs of source are only
+// collected once a name is needed. source is nil when reading a stream,
+// whose IDs are added as they are lexed.
+func (d *DotParser) startNames(source []byte) {
+	d.names = names{source: source, counts: make(map[string]int)}
+	if source == nil {
+		d.names.taken = make(map[string]bool)
+		d.names.ids.taken = d.names.taken
+	}
+}
+
+// A name for an anonymous graph (prefix "graph") or subgraph (prefix
+// "subgraph") which is not an ID of the input and has not been given before
+// in the parse. When streaming only the IDs read so far are known, so a
+// later ID may still be the same as the name. The IDs of anonymous graphs
+// are also marked Anonymous.
+func (d *DotParser) NextName(prefix string) string {
+	n := &d.names
+	if n.taken == nil {
+		n.taken = declared(n.source)
+	}
+	if n.counts == nil {
+		n.counts = make(map[string]int)
+	}
+	namer := d.Options.Namer
+	if namer == nil {
+		namer = DefaultNamer
+	}
+	for {
+		n.counts[prefix]++
+		name := namer.Name(prefix, n.counts[prefix])
+		if !n.taken[name] {
+			n.taken[name] = true
+			return name
+		}
+	}
+}
+
+// The IDs of text, those joined with + included.
+func declared(text []byte) map[string]bool {
+	s := idSet{taken: make(map[string]bool)}
+	if len(text) == 0 {
+		return s.taken
+	}
+	lexer := getLexer()
+	defer putLexer(lexer)
+	scan, err := lexer.Scanner(text)
+	if err != nil {
+		return s.taken
+	}
+	for tok, err, eof := scan.Next(); !eof; tok, err, eof = scan.Next() {
+		if err != nil {
+			// the parse fails on the same error
+			break
+		}
+		s.add(tok.(*lex.Token))
+	}
+	return s.taken
+}
+
+// Collects the IDs of a sequence of tokens. Each prefix of a join of quoted
+// strings with + is recorded along with the join, more than the IDs of the
+// input but never less.
+type idSet struct {
+	taken map[string]bool
+	last  *string // the value of the ID (or join) before a +
+	plus  bool    // whether the last token is a + following an ID
+}
+
+func (s *idSet) add(tok *lex.Token) {
+	if s.taken == nil {
+		return
+	}
+	switch tok.Type {
+	case TokenIds["ID"]:
+		id, _ := tok.Value.(ID)
+		v := id.Value
+		if s.plus {
+			v = *s.last + v
+		}
+		s.taken[v] = true
+		s.last, s.plus = &v, false
+	case TokenIds["+"]:
+		s.plus = s.last != nil
+	case TokenIds["COMMENT"]:
+	default:
+		s.last, s.plus = nil, false
+	}
+}
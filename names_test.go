@@ -0,0 +1,161 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+import (
+	. "github.com/timtadh/combos"
+	"github.com/timtadh/dot/ast"
+)
+
+// Records the names of the graphs and subgraphs entered
+type nameCallbacks struct {
+	names []string
+}
+
+func (c *nameCallbacks) Stmt(n *Node) error     { return nil }
+func (c *nameCallbacks) Exit(name string) error { return nil }
+
+func (c *nameCallbacks) Enter(name string, n *Node) error {
+	id := n.Get(0)
+	if name == "Graph" {
+		id = n.Get(1)
+	}
+	c.names = append(c.names, idValue(id))
+	return nil
+}
+
+// The names of the graphs and subgraphs of the text, anonymous ones starred
+func anonymousNames(t *test.T, d *DotParser, text string) []string {
+	n, err := d.Parse([]byte(text))
+	t.AssertNil(err)
+	f, err := BuildAST(n)
+	t.AssertNil(err)
+	var names []string
+	name := func(id *ast.ID) {
+		if id.Anonymous {
+			names = append(names, id.Value+"*")
+		} else {
+			names = append(names, id.Value)
+		}
+	}
+	var stmts func([]ast.Stmt)
+	stmts = func(body []ast.Stmt) {
+		for _, s := range body {
+			if sg, ok := s.(*ast.Subgraph); ok {
+				name(sg.ID)
+				stmts(sg.Stmts)
+			}
+		}
+	}
+	for _, decl := range f.Decls {
+		if g, ok := decl.(*ast.Graph); ok {
+			name(g.ID)
+			stmts(g.Stmts)
+		}
+	}
+	return names
+}
+
+func TestAnonymousNames(x *testing.T) {
+	t := (*test.T)(x)
+	text := `digraph { subgraph1; graph1 -> "subgraph" + "2"; subgraph { a } { b } subgraph s {} }
+	graph subgraph3 { subgraph { c } }`
+	expected := []string{"graph2*", "subgraph4*", "subgraph5*", "s", "subgraph3", "subgraph6*"}
+	d := NewDotParser(nil)
+	assertSameEvents(t, expected, anonymousNames(t, d, text))
+	// the names do not depend on the earlier parses
+	assertSameEvents(t, expected, anonymousNames(t, d, text))
+}
+
+func TestAnonymousNamesNamer(x *testing.T) {
+	t := (*test.T)(x)
+	d := NewDotParser(nil)
+	d.Options.Namer = NamerFunc(func(kind string, n int) string {
+		return fmt.Sprintf("_%v_%d", kind[:1], n)
+	})
+	names := anonymousNames(t, d, `graph { _s_1 -- _g_2; subgraph {} } graph {}`)
+	assertSameEvents(t, []string{"_g_1*", "_s_2*", "_g_3*"}, names)
+}
+
+func TestAnonymousNamesReader(x *testing.T) {
+	t := (*test.T)(x)
+	call := &nameCallbacks{}
+	text := `digraph g { graph1 } digraph { subgraph1 -> subgraph { x } }`
+	t.AssertNil(StreamParseReader(strings.NewReader(text), call))
+	assertSameEvents(t, []string{"g", "graph2", "subgraph2"}, call.names)
+}
+
+func TestAnonymousNamesRecover(x *testing.T) {
+	t := (*test.T)(x)
+	// the IDs after the anonymous graph are avoided as well
+	text := `digraph { a -> } digraph g { graph1; subgraph1 } graph { subgraph { b } }`
+	n, _, err := NewDotParser(nil).ParseRecover([]byte(text))
+	t.AssertNil(err)
+	f, err := BuildAST(n)
+	t.AssertNil(err)
+	var names []string
+	for _, decl := range f.Decls {
+		g := decl.(*ast.Graph)
+		names = append(names, g.ID.Value)
+		for _, s := range g.Stmts {
+			if sg, ok := s.(*ast.Subgraph); ok {
+				names = append(names, sg.ID.Value)
+			}
+		}
+	}
+	assertSameEvents(t, []string{"graph2", "g", "graph3", "subgraph2"}, names)
+}
+
+func TestAnonymousNamesModel(x *testing.T) {
+	t := (*test.T)(x)
+	graphs, err := ParseGraphs([]byte(`graph { subgraph subgraph1 { a } subgraph { b } subgraph { c } }`))
+	t.AssertNil(err)
+	g := graphs[0]
+	t.Assert(g.Anonymous, "expected an anonymous graph")
+	t.Assert(len(g.Subgraphs) == 3, "expected 3 subgraphs got %v", len(g.Subgraphs))
+	sg := g.Subgraph("subgraph1")
+	t.Assert(sg == g.Subgraphs[0] && !sg.Anonymous, "expected the named subgraph got %v", sg)
+	t.Assert(len(sg.Nodes) == 1 && sg.Nodes[0].Name == "a", "expected only a in subgraph1 got %v", sg.Nodes)
+	t.Assert(g.Subgraphs[1].Anonymous && g.Subgraphs[2].Anonymous, "expected anonymous subgraphs")
+	var buf bytes.Buffer
+	t.AssertNil(PrintGraph(&buf, g))
+	expected := `graph {
+	subgraph subgraph1 {
+		a;
+	}
+	subgraph {
+		b;
+	}
+	subgraph {
+		c;
+	}
+	a;
+	b;
+	c;
+}
+`
+	t.Assert(buf.String() == expected, "expected %q got %q", expected, buf.String())
+}
+
+func TestPrintAnonymous(x *testing.T) {
+	t := (*test.T)(x)
+	n, err := Parse([]byte(`graph { subgraph { a } subgraph1 }`))
+	t.AssertNil(err)
+	var buf bytes.Buffer
+	t.AssertNil(Print(&buf, n))
+	expected := `graph {
+	subgraph {
+		a;
+	}
+	subgraph1;
+}
+`
+	t.Assert(buf.String() == expected, "expected %q got %q", expected, buf.String())
+}
@@ -1,22 +1,94 @@
 package dot
 
 import (
+	"context"
 	"fmt"
+	"io"
 )
 
 import (
 	"github.com/timtadh/combos"
 )
 
+// Callbacks receive the graphs of a streaming parse as they are parsed:
+// Enter and Exit at the start and end of each graph and subgraph and Stmt
+// with each statement. See EventCallbacks for statements by kind.
 type Callbacks interface {
 	Stmt(*combos.Node) error
 	Enter(name string, n *combos.Node) error
 	Exit(name string) error
 }
 
+// Options controlling the behavior of a DotParser.
+type ParseOptions struct {
+	// Accept edge operators which do not match the type of the graph (`--`
+	// in a digraph or `->` in a graph) recording a warning in the
+	// Diagnostics of the parser rather than failing.
+	Lenient bool
+	// Honor the line markers (`# 12 "file"`) left by the C preprocessor
+	// reporting the file and line of Diagnostics and errors in the original
+	// source rather than in the parsed text. Lines starting with `#` are
+	// always discarded.
+	LineMarkers bool
+	// What to do with the duplicate edges of strict graphs, see StrictMode.
+	Strict StrictMode
+	// Make ParseReader recover from errors in the input. Each error is
+	// recorded in the Diagnostics of the parser and parsing resumes after
+	// the statement it was found in, see ParseRecover.
+	Recover bool
+	// Proposes the names of anonymous graphs and subgraphs, DefaultNamer
+	// when nil. See DotParser.NextName.
+	Namer Namer
+	// Stops the parse once done, the parse then fails with the error of the
+	// context. The context is checked before each statement.
+	Context context.Context
+	// Limits for parsing untrusted input, zero for no limit. Exceeding one
+	// fails the parse with an error of its own type: InputSizeError,
+	// DepthError, StmtCountError, IDLengthError, ChainLengthError and
+	// AttrCountError.
+	MaxBytes    int // the length of the input
+	MaxDepth    int // the nesting of subgraphs, 1 for no nested subgraphs
+	MaxStmts    int // the statements, a chain counts one per edge
+	MaxIDLength int // the length of an ID in bytes
+	MaxChain    int // the edges of an edge chain
+	MaxAttrs    int // the attributes of an attribute list
+}
+
+// How the duplicate edges of a strict graph are handled by NewGraph and by
+// the Callbacks of the parsers, see StrictCallbacks. A strict graph has at
+// most one edge between the same two nodes (in either direction for an
+// undirected graph).
+type StrictMode int
+
+const (
+	// Deliver every edge to the Callbacks as it is written, duplicates
+	// included. NewGraph merges the duplicates as with StrictMerge.
+	StrictKeep StrictMode = iota
+	// Merge the attributes of a duplicate edge into the first edge, in the
+	// order they are given, as graphviz does. Callbacks which are not an
+	// EdgeMerger are delivered the duplicate as any other edge.
+	StrictMerge
+	// Drop duplicate edges recording a warning in the Diagnostics of the
+	// parser for each one.
+	StrictReport
+)
+
+// A DotParser holds the state of a parse. It may be reused for several
+// parses one after another but must not be used by more than one goroutine
+// at a time, a Parser may be shared.
 type DotParser struct {
-	nextName  int
-	Callbacks Callbacks
+	names       names
+	limits      limits
+	directed    bool             // whether the graph being parsed is a digraph
+	keep        bool             // keep the statements in the tree when streaming
+	text        []byte           // the text being parsed, see source
+	shift       int              // from an offset of the input to an index of text
+	seen        map[diagKey]bool // the diagnostics recorded, see diagnose
+	Callbacks   Callbacks
+	Options     ParseOptions
+	Diagnostics []*Diagnostic
+	// The line markers of the input, nil unless Options.LineMarkers is set.
+	Lines *LineMap
 }
 
 func NewDotParser(c Callbacks) *DotParser {
@@ -32,19 +104,116 @@ func Parse(text []byte) (*combos.Node, error) {
 	return dotParse(text, nil)
 }
 
+// Parse the graphs read from r, see DotParser.ParseReader
+func StreamParseReader(r io.Reader, call Callbacks) error {
+	return NewDotParser(call).ParseReader(r)
+}
+
 func dotParse(text []byte, call Callbacks) (*combos.Node, error) {
-	s, err := Lexer.Scanner(text)
+	return NewDotParser(call).Parse(text)
+}
+
+// Parse the text with the options of the parser. If the parser has
+// Callbacks they are called as the text is parsed and the statements are
+// not retained in the returned tree.
+//
+// Invalid input results in a *SyntaxError, the failure of a callback in a
+// *CallbackError and exceeding a limit of the options in the error of the
+// limit.
+func (d *DotParser) Parse(text []byte) (*combos.Node, error) {
+	d.reset()
+	if ctx := d.Options.Context; ctx != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if max := d.Options.MaxBytes; max > 0 && len(text) > max {
+		return nil, &InputSizeError{Limit: max}
+	}
+	if err := d.checkTokens(text); err != nil {
+		return nil, err
+	}
+	if d.Options.LineMarkers {
+		d.Lines = NewLineMap(text)
+	}
+	d.startNames(text)
+	call := d.Callbacks
+	var failed *failedCallbacks
+	if call != nil {
+		failed = &failedCallbacks{Callbacks: d.strictCallbacks(call)}
+		d.Callbacks = failed
+		defer func() {
+			d.Callbacks = call
+		}()
+	}
+	lexer := getLexer()
+	defer putLexer(lexer)
+	s, err := lexer.Scanner(text)
 	if err != nil {
 		return nil, err
 	}
-	n, parseErr := DotGrammar().Parse(s, NewDotParser(call))
+	g := getGrammar()
+	defer putGrammar(g)
+	d.setText(text, 0)
+	defer d.setText(nil, 0)
+	n, parseErr := g.Parse(s, d)
+	if d.limits.err != nil {
+		return nil, d.limits.err
+	}
 	if parseErr != nil {
-		return nil, parseErr
+		if failed != nil && failed.err != nil {
+			return nil, failed.err
+		}
+		return nil, d.locate(d.syntaxError(parseErr, text, func(tc int) int { return tc }))
+	}
+	return locateRoot(n), nil
+}
+
+// Forget the outcome of the previous parse
+func (d *DotParser) reset() {
+	d.limits = limits{}
+	d.names = names{}
+	d.Diagnostics = nil
+	d.Lines = nil
+	d.seen = nil
+}
+
+// Move the position of a syntax error to the lines of the original source
+// when the parser honors line markers.
+func (d *DotParser) locate(e *SyntaxError) *SyntaxError {
+	if d.Lines != nil && e.Line != 0 {
+		e.File, e.Line = d.Lines.Position(e.Line)
+		_, e.EndLine = d.Lines.Position(e.EndLine)
 	}
-	return n, nil
+	return e
 }
 
-func (d *DotParser) NextName(prefix string) string {
-	d.nextName++
-	return fmt.Sprintf("%v%d", prefix, d.nextName)
+// Record a warning about a node. Backtracking can run a grammar action more
+// than once for the same input so duplicate warnings are dropped.
+func (d *DotParser) warn(n *combos.Node, format string, args ...interface{}) {
+	d.diagnose(SeverityWarning, n.Location(), format, args...)
+}
+
+func (d *DotParser) diagnose(sev Severity, l *combos.Location, format string, args ...interface{}) {
+	diag := &Diagnostic{
+		Severity: sev,
+		Message:  fmt.Sprintf(format, args...),
+		Location: l,
+	}
+	if d.Lines != nil && diag.Location != nil {
+		l := *diag.Location
+		diag.File, l.StartLine = d.Lines.Position(l.StartLine)
+		_, l.EndLine = d.Lines.Position(l.EndLine)
+		diag.Location = &l
+	}
+	key := diagKey{message: diag.Message, at: -1}
+	if l != nil {
+		key.at = l.StartTC
+	}
+	if d.seen[key] {
+		return
+	}
+	if d.seen == nil {
+		d.seen = make(map[diagKey]bool)
+	}
+	d.seen[key] = true
+	d.Diagnostics = append(d.Diagnostics, diag)
 }
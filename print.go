@@ -0,0 +1,340 @@
+package dot
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+import (
+	"github.com/timtadh/combos"
+	"github.com/timtadh/dot/ast"
+)
+
+// Write the tree produced by Parse as canonical dot. Each statement is put on
+// its own line, bodies are indented with tabs and IDs are only quoted when
+// they must be.
+func Print(w io.Writer, n *combos.Node) error {
+	f, err := BuildAST(n)
+	if err != nil {
+		return err
+	}
+	return PrintAST(w, f)
+}
+
+// Write a typed syntax tree as canonical dot.
+func PrintAST(w io.Writer, f *ast.File) error {
+	p := &printer{w: w}
+	p.file(f)
+	return p.err
+}
+
+// Write the semantic model of a graph as dot. The default attribute
+// statements have already been applied to the model so every node and edge
+// is written with its full set of attributes.
+func PrintGraph(w io.Writer, g *Graph) error {
+	p := &printer{w: w}
+	p.model(g)
+	return p.err
+}
+
+// non-ASCII characters are letters, as are invalid bytes which the lexer
+// accepts in \200-\377 and the regexp reads as U+FFFD
+var bareID = regexp.MustCompile(`^[a-zA-Z_\x{80}-\x{10FFFF}][a-zA-Z0-9_\x{80}-\x{10FFFF}]*$`)
+var numeralID = regexp.MustCompile(`^-?(\.[0-9]+|[0-9]+(\.[0-9]*)?)$`)
+
+// Quote an ID unless it would be lexed as an ID as it stands.
+func quoteID(id string) string {
+	if (bareID.MatchString(id) || numeralID.MatchString(id)) && !isKeyword(id) {
+		return id
+	}
+	// only quotes are unescaped by the lexer, other escape sequences are
+	// part of the value
+	return `"` + strings.Replace(id, `"`, `\"`, -1) + `"`
+}
+
+func isKeyword(id string) bool {
+	for _, kw := range Keywords {
+		if strings.EqualFold(id, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCompass(id string) bool {
+	switch id {
+	case "n", "ne", "e", "se", "s", "sw", "w", "nw", "c", "_":
+		return true
+	}
+	return false
+}
+
+type printer struct {
+	w        io.Writer
+	err      error
+	indent   int
+	directed bool
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+func (p *printer) line(format string, args ...interface{}) {
+	p.printf("%s", strings.Repeat("\t", p.indent))
+	p.printf(format, args...)
+	p.printf("\n")
+}
+
+func (p *printer) id(id *ast.ID) string {
+	if id.Kind == ast.HTML {
+		return "<" + id.Value + ">"
+	}
+	return quoteID(id.Value)
+}
+
+// The name of a graph or subgraph followed by a space, the generated names
+// of anonymous graphs are left out so that they stay anonymous.
+func (p *printer) name(id *ast.ID) string {
+	if id.Anonymous {
+		return ""
+	}
+	return p.id(id) + " "
+}
+
+// The name of a graph or subgraph of the model followed by a space, see name
+func modelName(g *Graph) string {
+	if g.Anonymous {
+		return ""
+	}
+	return modelID(g.Name, g.NameKind) + " "
+}
+
+// A name or value of the model as an ID of its kind, see printer.id
+func modelID(value string, kind ast.IDKind) string {
+	if kind == ast.HTML {
+		return "<" + value + ">"
+	}
+	return quoteID(value)
+}
+
+func (p *printer) file(f *ast.File) {
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.Graph:
+			p.graph(d)
+		case *ast.Comment:
+			p.comment(d)
+		}
+	}
+}
+
+func (p *printer) graph(g *ast.Graph) {
+	p.directed = g.Directed
+	kind := "graph"
+	if g.Directed {
+		kind = "digraph"
+	}
+	if g.Strict {
+		kind = "strict " + kind
+	}
+	p.leading(&g.Trivia)
+	p.line("%s %s{", kind, p.name(g.ID))
+	p.body(g.Stmts)
+	p.line("}%s", p.trailing(&g.Trivia))
+}
+
+func (p *printer) body(stmts []ast.Stmt) {
+	p.indent++
+	for _, stmt := range stmts {
+		p.stmt(stmt)
+	}
+	p.indent--
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	trailing := ""
+	if c, ok := stmt.(interface {
+		Comments() *ast.Trivia
+	}); ok {
+		p.leading(c.Comments())
+		trailing = p.trailing(c.Comments())
+	}
+	switch s := stmt.(type) {
+	case *ast.NodeStmt:
+		p.line("%s%s;%s", p.nodeID(s.Node), p.attrs(s.Attrs, true), trailing)
+	case *ast.EdgeStmt:
+		op := "--"
+		if p.directed {
+			op = "->"
+		}
+		for i, v := range s.Vertices {
+			if i == 0 {
+				p.vertexStart(v)
+			} else {
+				p.printf(" %s ", op)
+				p.vertexEnd(v)
+			}
+		}
+		p.printf("%s;%s\n", p.attrs(s.Attrs, true), trailing)
+	case *ast.AttrStmt:
+		p.line("%v%s;%s", s.Kind, p.attrs(s.Attrs, false), trailing)
+	case *ast.Assign:
+		p.line("%s=%s;%s", p.id(s.Name), p.id(s.Value), trailing)
+	case *ast.Subgraph:
+		p.line("subgraph %s{", p.name(s.ID))
+		p.body(s.Stmts)
+		p.line("}%s", trailing)
+	case *ast.Comment:
+		p.comment(s)
+	}
+}
+
+// The first vertex of an edge starts the line
+func (p *printer) vertexStart(v ast.Vertex) {
+	p.printf("%s", strings.Repeat("\t", p.indent))
+	p.vertexEnd(v)
+}
+
+// A vertex continuing a line. Subgraphs span several lines and leave the
+// line open after their closing brace.
+func (p *printer) vertexEnd(v ast.Vertex) {
+	switch x := v.(type) {
+	case *ast.NodeID:
+		p.printf("%s", p.nodeID(x))
+	case *ast.Subgraph:
+		p.printf("subgraph %s{\n", p.name(x.ID))
+		p.body(x.Stmts)
+		p.printf("%s}", strings.Repeat("\t", p.indent))
+	}
+}
+
+func (p *printer) nodeID(n *ast.NodeID) string {
+	s := p.id(n.ID)
+	if n.Port != nil {
+		s += ":" + p.id(n.Port.ID)
+		if n.Port.Compass != nil {
+			s += ":" + n.Port.Compass.Value
+		}
+	}
+	return s
+}
+
+// An attribute list with a leading space. Empty lists are omitted unless
+// required by the statement.
+func (p *printer) attrs(attrs []*ast.Attr, optional bool) string {
+	if len(attrs) == 0 && optional {
+		return ""
+	}
+	parts := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		parts = append(parts, p.id(attr.Name)+"="+p.id(attr.Value))
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
+
+func (p *printer) comment(c *ast.Comment) {
+	p.line("%s", strings.TrimRight(c.Text, "\r\n"))
+}
+
+// The comments attached before a graph or statement each on lines of their
+// own, see ast.AttachComments.
+func (p *printer) leading(t *ast.Trivia) {
+	for _, c := range t.Leading {
+		p.comment(c)
+	}
+}
+
+// The comments attached after a graph or statement to follow it on its line
+func (p *printer) trailing(t *ast.Trivia) string {
+	s := ""
+	for _, c := range t.Trailing {
+		s += " " + strings.TrimRight(c.Text, "\r\n")
+	}
+	return s
+}
+
+func (p *printer) model(g *Graph) {
+	kind := "graph"
+	op := "--"
+	if g.Directed {
+		kind = "digraph"
+		op = "->"
+	}
+	if g.Strict {
+		kind = "strict " + kind
+	}
+	p.line("%s %s{", kind, modelName(g))
+	p.indent++
+	p.modelAttrs(g.Attrs)
+	for _, sg := range g.Subgraphs {
+		p.modelSubgraph(sg)
+	}
+	for _, n := range g.Nodes {
+		p.line("%s%s;", modelID(n.Name, n.NameKind), p.attrMap(n.Attrs))
+	}
+	for _, e := range g.Edges {
+		p.line("%s %s %s%s;",
+			p.modelPort(e.From, e.FromPort), op, p.modelPort(e.To, e.ToPort),
+			p.attrMap(e.Attrs))
+	}
+	p.indent--
+	p.line("}")
+}
+
+// Subgraphs of the model only record their membership and graph attributes,
+// nodes and edges are written with their attributes by the root.
+func (p *printer) modelSubgraph(g *Graph) {
+	p.line("subgraph %s{", modelName(g))
+	p.indent++
+	p.modelAttrs(g.Attrs)
+	for _, sg := range g.Subgraphs {
+		p.modelSubgraph(sg)
+	}
+	for _, n := range g.Nodes {
+		p.line("%s;", modelID(n.Name, n.NameKind))
+	}
+	p.indent--
+	p.line("}")
+}
+
+func (p *printer) modelAttrs(attrs Attrs) {
+	if len(attrs) > 0 {
+		p.line("graph%s;", p.attrMap(attrs))
+	}
+}
+
+func (p *printer) modelPort(n *Vertex, port string) string {
+	name := modelID(n.Name, n.NameKind)
+	if port == "" {
+		return name
+	}
+	if i := strings.LastIndex(port, ":"); i >= 0 && isCompass(port[i+1:]) {
+		return name + ":" + quoteID(port[:i]) + ":" + port[i+1:]
+	}
+	return name + ":" + quoteID(port)
+}
+
+// Attributes of the model in name order so the output is deterministic.
+func (p *printer) attrMap(attrs Attrs) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		v := attrs[name]
+		parts = append(parts, quoteID(name)+"="+modelID(v.Value, v.Kind))
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,144 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"bytes"
+)
+
+import (
+	"github.com/timtadh/dot/ast"
+)
+
+func roundTrip(t *test.T, text string) string {
+	n, err := Parse([]byte(text))
+	t.AssertNil(err)
+	var buf bytes.Buffer
+	t.AssertNil(Print(&buf, n))
+	printed := buf.String()
+	t.Log(printed)
+	m, err := Parse(buf.Bytes())
+	t.AssertNil(err)
+	t.Assert(n.Equal(m), "expected %v got %v", n, m)
+	return printed
+}
+
+func TestPrintCanonical(x *testing.T) {
+	t := (*test.T)(x)
+	printed := roundTrip(t, `digraph G {rankdir=LR; node [shape=box]; a -> b [label="x y", weight=2]; "node" -> c:p:ne}`)
+	expected := `digraph G {
+	rankdir=LR;
+	node [shape=box];
+	a -> b [label="x y", weight=2];
+	"node" -> c:p:ne;
+}
+`
+	t.Assert(printed == expected, "expected %q got %q", expected, printed)
+}
+
+func TestPrintRoundTrip(x *testing.T) {
+	t := (*test.T)(x)
+	roundTrip(t, `
+	// a comment
+	strict graph s {
+		a -- {b c} -- d [w=0.5]
+		subgraph cluster_0 {
+			label="cluster \"zero\""
+			edge []
+			e:n
+			subgraph { f }
+		}
+		/* trailing */
+	}
+	digraph html {
+		a [label=<<b>bold</b>>]
+		b [label="<b>"]
+	}`)
+}
+
+func TestPrintHTML(x *testing.T) {
+	t := (*test.T)(x)
+	printed := roundTrip(t, `digraph { a [label=<<b>bold</b>>, tip="<b>"] }`)
+	t.Assert(bytes.Contains([]byte(printed), []byte(`label=<<b>bold</b>>`)), "html label was not preserved %v", printed)
+	t.Assert(bytes.Contains([]byte(printed), []byte(`tip="<b>"`)), "quoted label was not preserved %v", printed)
+}
+
+func TestPrintUTF8(x *testing.T) {
+	t := (*test.T)(x)
+	printed := roundTrip(t, `digraph 図 { "日本" -> "Größe" [label="ü"] }`)
+	expected := `digraph 図 {
+	日本 -> Größe [label=ü];
+}
+`
+	t.Assert(printed == expected, "expected %q got %q", expected, printed)
+}
+
+func TestPrintComments(x *testing.T) {
+	t := (*test.T)(x)
+	f, err := ParseAST([]byte(commentedText))
+	t.AssertNil(err)
+	ast.AttachComments(f)
+	var buf bytes.Buffer
+	t.AssertNil(PrintAST(&buf, f))
+	expected := `// about g
+digraph g {
+	// the defaults
+	// for every node
+	node [shape=box]; // boxes
+	// detached
+	a -> b; /* edge */
+	subgraph s {
+		// c
+		c;
+	} // end of s
+	// at the end
+}
+`
+	t.Assert(buf.String() == expected, "expected %q got %q", expected, buf.String())
+}
+
+func TestPrintGraphModel(x *testing.T) {
+	t := (*test.T)(x)
+	graphs, err := ParseGraphs([]byte(`digraph {
+		node [shape=box]
+		a -> b:p:n
+		subgraph cluster_0 { c; label=C }
+	}`))
+	t.AssertNil(err)
+	var buf bytes.Buffer
+	t.AssertNil(PrintGraph(&buf, graphs[0]))
+	t.Log(buf.String())
+	again, err := ParseGraphs(buf.Bytes())
+	t.AssertNil(err)
+	g := again[0]
+	t.Assert(len(g.Nodes) == 3, "expected 3 nodes got %v", len(g.Nodes))
+	t.Assert(g.Node("c").Attrs["shape"].Value == "box", "expected box got %v", g.Node("c").Attrs)
+	t.Assert(g.Edges[0].ToPort == "p:n", "expected p:n got %v", g.Edges[0].ToPort)
+	t.Assert(g.Subgraph("cluster_0").Attrs["label"].Value == "C", "lost the cluster label")
+}
+
+func TestPrintGraphModelHTML(x *testing.T) {
+	t := (*test.T)(x)
+	text := `digraph <G> { graph [label=<<i>t</i>>]; a [label=<<b>x</b>>]; b [label="<b>x</b>"] }`
+	graphs, err := ParseGraphs([]byte(text))
+	t.AssertNil(err)
+	var buf bytes.Buffer
+	t.AssertNil(PrintGraph(&buf, graphs[0]))
+	expected := `digraph <G> {
+	graph [label=<<i>t</i>>];
+	a [label=<<b>x</b>>];
+	b [label="<b>x</b>"];
+}
+`
+	t.Assert(buf.String() == expected, "expected %q got %q", expected, buf.String())
+	again, err := ParseGraphs(buf.Bytes())
+	t.AssertNil(err)
+	g := again[0]
+	t.Assert(g.NameKind == ast.HTML, "expected an HTML name got %v", g.NameKind)
+	t.Assert(g.Attrs["label"].Kind == ast.HTML, "expected an HTML label got %v", g.Attrs["label"].Kind)
+	a := g.Node("a").Attrs["label"]
+	t.Assert(a.Kind == ast.HTML && a.Value == "<b>x</b>", "expected an HTML label got %v %v", a.Kind, a)
+	b := g.Node("b").Attrs["label"]
+	t.Assert(b.Kind == ast.Quoted && b.Value == "<b>x</b>", "expected a quoted label got %v %v", b.Kind, b)
+}
@@ -0,0 +1,863 @@
+package dot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+import (
+	"github.com/timtadh/combos"
+	lex "github.com/timtadh/lexmachine"
+	"github.com/timtadh/lexmachine/machines"
+)
+
+// How much of the input is read at a time by StreamParseReader
+const readSize = 64 * 1024
+
+// Parse the graphs read from r delivering their statements to the Callbacks
+// of the parser as they are parsed. Unlike Parse the input is never held in
+// memory in its entirety: each statement at the top level of a graph body is
+// lexed and parsed on its own and is discarded once it has been delivered.
+// The memory used is therefore bounded by the size of the largest top level
+// statement rather than by the size of the input. A subgraph is a single
+// statement, held in memory along with everything nested in it until its
+// closing `}`, so a graph whose statements all sit inside one cluster is
+// held in memory in its entirety.
+func (d *DotParser) ParseReader(r io.Reader) error {
+	return d.parseReader(r, nil, d.Options.Recover, nil)
+}
+
+// Parse the text recovering from the errors in it. Each error is recorded in
+// the Diagnostics of the parser, which are returned, and parsing resumes at
+// the next statement: after the next `;` or `}` or at a token which begins
+// a statement. Errors in the body of a subgraph are recovered from in the
+// same way, keeping the subgraph and the statements of its body which
+// parsed. The tree holds the statements which parsed and the Callbacks of
+// the parser are delivered for them. An error is only returned if a
+// callback fails.
+func (d *DotParser) ParseRecover(text []byte) (*combos.Node, []*Diagnostic, error) {
+	tree := combos.NewNode("Graphs")
+	d.keep = true
+	defer func() {
+		d.keep = false
+	}()
+	err := d.parseReader(bytes.NewReader(text), text, true, tree)
+	return locateRoot(tree), d.Diagnostics, err
+}
+
+// Parse the graphs read from r. source is the whole input when it is known,
+// the anonymous graphs are then named apart from all of its IDs rather than
+// only those read so far.
+func (d *DotParser) parseReader(r io.Reader, source []byte, recovering bool, tree *combos.Node) error {
+	lexer := getLexer()
+	defer putLexer(lexer)
+	adj := lexer.adj
+	d.reset()
+	if d.Options.LineMarkers {
+		d.Lines = new(LineMap)
+	}
+	d.startNames(source)
+	if max := d.Options.MaxBytes; max > 0 {
+		r = &limitedReader{r: r, max: max}
+	}
+	tokens := &tokenReader{r: r, lexer: lexer.Lexer, adj: adj, lines: d.Lines, ids: &d.names.ids, line: 1, col: 1}
+	p := &streamParser{
+		tokens:  tokens,
+		lexer:   lexer.Lexer,
+		adj:     adj,
+		grammar: getGrammar(),
+		d:       d,
+		recover: recovering,
+		tree:    tree,
+	}
+	call := d.Callbacks
+	if call != nil {
+		p.failed = &failedCallbacks{Callbacks: d.strictCallbacks(call)}
+		p.call = p.failed
+	}
+	defer func() {
+		d.Callbacks = call
+		putGrammar(p.grammar)
+	}()
+	err := p.parse()
+	if e, ok := err.(*SyntaxError); ok {
+		return d.locate(e)
+	}
+	return err
+}
+
+type streamParser struct {
+	tokens  *tokenReader
+	lexer   *lex.Lexer
+	adj     *adjuster
+	grammar *combos.Grammar
+	d       *DotParser
+	call    Callbacks
+	failed  *failedCallbacks // records the errors of call
+	recover bool
+	tree    *combos.Node // the tree being built by ParseRecover or nil
+}
+
+func (p *streamParser) parse() error {
+	skipping := false
+	for {
+		tok, err := p.tokens.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			if err = p.lexError(err); err != nil {
+				return err
+			}
+			continue
+		}
+		switch Tokens[tok.Type] {
+		case "COMMENT":
+			if p.tree != nil {
+				n, err := p.parseChunk(tok, tokenEnd(tok), "", "", nil)
+				if err != nil {
+					return err
+				}
+				p.tree.Children = append(p.tree.Children, n.Children...)
+			}
+			p.tokens.release(tokenEnd(tok))
+		case "STRICT", "GRAPH", "DIGRAPH":
+			skipping = false
+			if err := p.graph(tok); err != nil {
+				return err
+			}
+		default:
+			if !p.recover {
+				return unexpected(tok)
+			}
+			// skip to the start of the next graph
+			if !skipping {
+				p.d.diagnose(SeverityError, tokenLocation(tok), "%v", unexpected(tok))
+				skipping = true
+			}
+			p.tokens.release(tokenEnd(tok))
+		}
+	}
+}
+
+// Parse a graph. The header is parsed on its own to deliver the Enter
+// callback, followed by each top level statement of the body.
+func (p *streamParser) graph(first *lex.Token) error {
+	header := []*lex.Token{first}
+	kind := ""
+	strict := Tokens[first.Type] == "STRICT"
+	// the limits the grammar cannot check, see tokenCounts
+	var counts tokenCounts
+	if err := counts.add(&p.d.Options, first); err != nil {
+		return err
+	}
+	for {
+		last := header[len(header)-1]
+		switch Tokens[last.Type] {
+		case "GRAPH", "DIGRAPH":
+			if kind == "" {
+				kind = strings.ToLower(Tokens[last.Type])
+			}
+		}
+		// at most `strict digraph ID {`, or longer by an ID joined with
+		// `+`, anything longer is left for the grammar to reject unless
+		// recovering in which case the header runs up to the `{`
+		if Tokens[last.Type] == "{" || (len(header) >= 4 && !p.recover && !joining(header)) {
+			break
+		}
+		tok, err := p.tokens.next()
+		if err == io.EOF {
+			if p.recover {
+				p.d.diagnose(SeverityError, tokenLocation(last), "%v", unexpectedEOF(last))
+				return nil
+			}
+			return unexpectedEOF(last)
+		} else if err != nil {
+			if err = p.lexError(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := counts.add(&p.d.Options, tok); err != nil {
+			return err
+		}
+		header = append(header, tok)
+	}
+	open := header[len(header)-1]
+	hc := &headerCallbacks{Callbacks: p.call, name: "Graph"}
+	n, err := p.parseChunk(header[0], tokenEnd(open), "", "}", hc)
+	if err != nil && p.recover && !p.stopped() {
+		p.diagnoseError(err)
+		if kind == "" {
+			kind = "graph"
+		}
+		if hc.node != nil {
+			// the graph was entered before the header failed
+			n, err = combos.NewNode("Graphs").AddKid(hc.node.AddKid(combos.NewNode("Stmts"))), nil
+		} else {
+			// parse the body as that of an anonymous graph
+			start := kind
+			if strict {
+				start = "strict " + kind
+			}
+			n, err = p.parseChunk(open, open.TC, start+" ", "{}", hc)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	p.tokens.release(tokenEnd(open))
+	var graph, stmts *combos.Node
+	if p.tree != nil {
+		graph = n.Get(0)
+		stmts = graph.Get(2)
+		p.tree.AddKid(graph)
+	}
+
+	prefix := fmt.Sprintf("%v _ {", kind)
+	var stmt []*lex.Token
+	skipping := false // dropping the rest of a statement with a lex error
+	flush := func() error {
+		if len(stmt) == 0 {
+			return nil
+		}
+		if err := p.stmt(stmt, prefix, stmts); err != nil {
+			return err
+		}
+		p.tokens.release(tokenEnd(stmt[len(stmt)-1]))
+		stmt = stmt[:0]
+		return nil
+	}
+	// the unclosed brackets of the statement, `[` or `{`
+	var brackets []string
+	last := open // the graph ends here when it is unclosed
+	for {
+		tok, err := p.tokens.next()
+		if err == io.EOF {
+			if !p.recover {
+				return unexpectedEOF(open)
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			p.d.diagnose(SeverityError, tokenLocation(open), "%v", unexpectedEOF(open))
+			return p.closeGraph(graph, header[0], open, last)
+		} else if err != nil {
+			if err = p.lexError(err); err != nil {
+				return err
+			}
+			// the statement is dropped, along with what remains of it
+			// when the error was inside of brackets
+			stmt = stmt[:0]
+			skipping = len(brackets) > 0
+			continue
+		}
+		last = tok
+		name := Tokens[tok.Type]
+		if err := counts.add(&p.d.Options, tok); err != nil {
+			return err
+		}
+		if name == "}" && !hasBrace(brackets) {
+			if err := flush(); err != nil {
+				return err
+			}
+			p.tokens.release(tokenEnd(tok))
+			return p.closeGraph(graph, header[0], open, tok)
+		}
+		if len(brackets) == 0 && len(stmt) > 0 && startsStmt(stmt, name) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		brackets = nest(brackets, name)
+		if skipping {
+			p.tokens.release(tokenEnd(tok))
+			skipping = len(brackets) > 0
+			continue
+		}
+		stmt = append(stmt, tok)
+		if len(brackets) == 0 && (name == ";" || (name == "COMMENT" && len(stmt) == 1)) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// The unclosed brackets once the token of type name has been read
+func nest(brackets []string, name string) []string {
+	switch name {
+	case "{", "[":
+		brackets = append(brackets, name)
+	case "]":
+		if len(brackets) > 0 && brackets[len(brackets)-1] == "[" {
+			brackets = brackets[:len(brackets)-1]
+		}
+	case "}":
+		// closes any unclosed `[` along with the `{`
+		for len(brackets) > 0 && brackets[len(brackets)-1] != "{" {
+			brackets = brackets[:len(brackets)-1]
+		}
+		if len(brackets) > 0 {
+			brackets = brackets[:len(brackets)-1]
+		}
+	}
+	return brackets
+}
+
+// Parse the statement of a graph body made of tokens delivering its
+// callbacks. The statement is added to stmts when building a tree. When
+// recovering a statement which fails is dropped, unless it is a subgraph
+// whose body is then recovered statement by statement.
+func (p *streamParser) stmt(tokens []*lex.Token, prefix string, stmts *combos.Node) error {
+	bc := &bodyCallbacks{Callbacks: p.call, buffer: p.recover}
+	n, err := p.parseChunk(tokens[0], tokenEnd(tokens[len(tokens)-1]), prefix, "}", bc)
+	if err != nil && p.recover && !p.stopped() {
+		if open, close := subgraphBody(tokens); open >= 0 {
+			diags := len(p.d.Diagnostics)
+			if err := p.subgraph(tokens, open, close, prefix, stmts); err != nil {
+				return err
+			}
+			if len(p.d.Diagnostics) > diags {
+				return nil
+			}
+			// the body parsed so the error was in the subgraph itself
+		}
+		p.diagnoseError(err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := bc.replay(); err != nil {
+		return err
+	}
+	if stmts != nil {
+		stmts.Children = append(stmts.Children, n.Get(0).Get(2).Children...)
+	}
+	return nil
+}
+
+// The indices of the braces of the body of a subgraph statement, `subgraph
+// ID { ... }` or `{ ... }` optionally followed by a `;`, -1 when the tokens
+// are some other statement
+func subgraphBody(tokens []*lex.Token) (open, close int) {
+	open = 0
+	if Tokens[tokens[0].Type] == "SUBGRAPH" {
+		open = 1
+		if len(tokens) > 1 && Tokens[tokens[1].Type] == "ID" {
+			open = 2
+			// the ID may be joined with +
+			for open+1 < len(tokens) && Tokens[tokens[open].Type] == "+" && Tokens[tokens[open+1].Type] == "ID" {
+				open += 2
+			}
+		}
+	}
+	if open >= len(tokens) || Tokens[tokens[open].Type] != "{" {
+		return -1, -1
+	}
+	var brackets []string
+	for i := open; i < len(tokens); i++ {
+		brackets = nest(brackets, Tokens[tokens[i].Type])
+		if len(brackets) > 0 {
+			continue
+		}
+		if i == len(tokens)-1 || (i == len(tokens)-2 && Tokens[tokens[i+1].Type] == ";") {
+			return open, i
+		}
+		break
+	}
+	return -1, -1
+}
+
+// Recover the subgraph statement made of tokens whose body is between the
+// braces at open and close. The header is parsed on its own to deliver the
+// Enter callback, followed by each statement of the body. The statements
+// which fail are dropped, the subgraph is delivered with the others.
+func (p *streamParser) subgraph(tokens []*lex.Token, open, close int, prefix string, stmts *combos.Node) error {
+	hc := &headerCallbacks{Callbacks: p.call, name: "SubGraph"}
+	_, err := p.parseChunk(tokens[0], tokenEnd(tokens[open]), prefix, "}}", hc)
+	if err != nil {
+		if p.stopped() {
+			return err
+		}
+		p.diagnoseError(err)
+		return nil
+	}
+	sg := hc.node
+	body := sg.Get(1)
+	body.Children = nil
+	var kept *combos.Node // the statements of the body kept in the tree
+	if stmts != nil {
+		kept = body
+	}
+	for _, stmt := range splitStmts(tokens[open+1 : close]) {
+		if err := p.stmt(stmt, prefix, kept); err != nil {
+			return err
+		}
+	}
+	body.SetLocation(join(tokenLocation(tokens[open]), tokenLocation(tokens[close])))
+	sg.SetLocation(join(tokenLocation(tokens[0]), body.Location()))
+	if p.call != nil {
+		if err := p.call.Exit("SubGraph"); err != nil {
+			return err
+		}
+		if err := p.call.Stmt(sg); err != nil {
+			return err
+		}
+	}
+	if stmts != nil {
+		stmts.AddKid(sg)
+	}
+	return nil
+}
+
+// The statements of a body, split as the body of a graph is split as it is
+// read
+func splitStmts(tokens []*lex.Token) [][]*lex.Token {
+	var split [][]*lex.Token
+	var stmt []*lex.Token
+	var brackets []string
+	for _, tok := range tokens {
+		name := Tokens[tok.Type]
+		if len(brackets) == 0 && len(stmt) > 0 && startsStmt(stmt, name) {
+			split = append(split, stmt)
+			stmt = nil
+		}
+		brackets = nest(brackets, name)
+		stmt = append(stmt, tok)
+		if len(brackets) == 0 && (name == ";" || (name == "COMMENT" && len(stmt) == 1)) {
+			split = append(split, stmt)
+			stmt = nil
+		}
+	}
+	if len(stmt) > 0 {
+		split = append(split, stmt)
+	}
+	return split
+}
+
+func hasBrace(brackets []string) bool {
+	for _, b := range brackets {
+		if b == "{" {
+			return true
+		}
+	}
+	return false
+}
+
+// End the graph whose header begins at first and whose body is from open to
+// end. The body of the graph was parsed in pieces so its location, and the
+// location of the graph, are those of the tokens.
+func (p *streamParser) closeGraph(graph *combos.Node, first, open, end *lex.Token) error {
+	if graph != nil {
+		body := join(tokenLocation(open), tokenLocation(end))
+		graph.Get(2).SetLocation(body)
+		graph.SetLocation(join(tokenLocation(first), body))
+	}
+	if p.call != nil {
+		return p.call.Exit("Graph")
+	}
+	return nil
+}
+
+// A lex error, recorded when recovering in which case the rest of the line
+// the error is on is skipped.
+func (p *streamParser) lexError(err error) error {
+	var at *combos.Location
+	switch e := err.(type) {
+	case *lexError:
+		at = e.at
+	case *machines.UnconsumedInput:
+		at = &combos.Location{
+			StartTC:     e.FailTC,
+			EndTC:       e.FailTC + 1,
+			StartLine:   e.FailLine,
+			StartColumn: e.FailColumn,
+			EndLine:     e.FailLine,
+			EndColumn:   e.FailColumn,
+		}
+	}
+	if !p.recover || at == nil {
+		return err
+	}
+	p.d.diagnose(SeverityError, at, "%v", err)
+	return p.tokens.skipLine(at.StartTC)
+}
+
+// Whether the parse must stop rather than recover: a callback failed or a
+// limit was exceeded.
+func (p *streamParser) stopped() bool {
+	return (p.failed != nil && p.failed.err != nil) || p.d.limits.err != nil
+}
+
+// Record a parse error as a Diagnostic.
+func (p *streamParser) diagnoseError(err error) {
+	e, ok := err.(*SyntaxError)
+	if !ok || e.Line == 0 {
+		p.d.diagnose(SeverityError, nil, "%v", err)
+		return
+	}
+	p.d.diagnose(SeverityError, &combos.Location{
+		StartTC:     e.Offset,
+		EndTC:       e.Offset + len(e.Token),
+		StartLine:   e.Line,
+		StartColumn: e.Column,
+		EndLine:     e.EndLine,
+		EndColumn:   e.EndColumn,
+	}, "%v", e.describe())
+}
+
+// Parse the input text from the start of the first token up to end wrapped
+// in the given prefix and suffix. The positions of the nodes are those of the
+// input.
+func (p *streamParser) parseChunk(first *lex.Token, end int, prefix, suffix string, call Callbacks) (*combos.Node, error) {
+	text := make([]byte, 0, len(prefix)+end-first.TC+len(suffix))
+	text = append(text, prefix...)
+	text = append(text, p.tokens.bytes(first.TC, end)...)
+	text = append(text, suffix...)
+	*p.adj = adjuster{
+		start: len(prefix),
+		tc:    first.TC,
+		line:  first.StartLine,
+		col:   first.StartColumn,
+	}
+	s, err := p.lexer.Scanner(text)
+	if err != nil {
+		return nil, err
+	}
+	if ctx := p.d.Options.Context; ctx != nil && ctx.Err() != nil {
+		p.d.limits.err = ctx.Err()
+		return nil, ctx.Err()
+	}
+	p.d.Callbacks = call
+	p.d.setText(text, p.adj.start-p.adj.tc)
+	defer p.d.setText(nil, 0)
+	n, parseErr := p.grammar.Parse(s, p.d)
+	if p.d.limits.err != nil {
+		return nil, p.d.limits.err
+	}
+	if parseErr != nil {
+		if p.failed != nil && p.failed.err != nil {
+			return nil, p.failed.err
+		}
+		adj := *p.adj
+		return nil, p.d.syntaxError(parseErr, text, func(tc int) int {
+			return tc - adj.tc + adj.start
+		})
+	}
+	return n, nil
+}
+
+// Whether a token of type name begins a new statement when it follows the
+// tokens of an unterminated statement at the top level of a graph body.
+func startsStmt(stmt []*lex.Token, name string) bool {
+	switch name {
+	case "ID", "NODE", "EDGE", "GRAPH", "SUBGRAPH", "{", "COMMENT":
+	default:
+		return false
+	}
+	switch Tokens[stmt[len(stmt)-1].Type] {
+	case "ID":
+		// subgraph ID { ... } is a single statement, as is subgraph ID + ID
+		// { ... }
+		if name == "{" {
+			if i := idStart(stmt, len(stmt)-1); i > 0 && Tokens[stmt[i-1].Type] == "SUBGRAPH" {
+				return false
+			}
+		}
+		return true
+	case "]", "}":
+		return true
+	}
+	return false
+}
+
+// The index of the first ID of the IDs joined with + which end with the ID
+// at end, end itself when it is not joined
+func idStart(tokens []*lex.Token, end int) int {
+	for end >= 2 && Tokens[tokens[end-1].Type] == "+" && Tokens[tokens[end-2].Type] == "ID" {
+		end -= 2
+	}
+	return end
+}
+
+// Whether the tokens end in the middle of IDs joined with +, with a + or
+// with an ID following one
+func joining(tokens []*lex.Token) bool {
+	last := len(tokens) - 1
+	switch Tokens[tokens[last].Type] {
+	case "+":
+		return last > 0 && Tokens[tokens[last-1].Type] == "ID"
+	case "ID":
+		return last > 0 && Tokens[tokens[last-1].Type] == "+"
+	}
+	return false
+}
+
+func tokenEnd(tok *lex.Token) int {
+	return tok.TC + len(tok.Lexeme)
+}
+
+func unexpected(tok *lex.Token) error {
+	return fmt.Errorf("Unexpected %v %q at %d:%d",
+		Tokens[tok.Type], string(tok.Lexeme), tok.StartLine, tok.StartColumn)
+}
+
+func tokenLocation(tok *lex.Token) *combos.Location {
+	return &combos.Location{
+		StartTC:     tok.TC,
+		EndTC:       tokenEnd(tok),
+		StartLine:   tok.StartLine,
+		StartColumn: tok.StartColumn,
+		EndLine:     tok.EndLine,
+		EndColumn:   tok.EndColumn,
+	}
+}
+
+func unexpectedEOF(open *lex.Token) error {
+	return fmt.Errorf("Unexpected end of input, unclosed %q at %d:%d",
+		string(open.Lexeme), open.StartLine, open.StartColumn)
+}
+
+// Forwards only the Enter callback of the header of a graph, or of a
+// subgraph, named name. The header is parsed with an empty body whose Exit
+// is delivered once the real body has been parsed.
+type headerCallbacks struct {
+	Callbacks
+	name string
+	node *combos.Node // the graph or subgraph entered
+}
+
+func (h *headerCallbacks) Enter(name string, n *combos.Node) error {
+	if name != h.name {
+		return nil
+	}
+	h.node = n
+	if h.Callbacks == nil {
+		return nil
+	}
+	return h.Callbacks.Enter(name, n)
+}
+
+func (h *headerCallbacks) Stmt(n *combos.Node) error {
+	return nil
+}
+
+func (h *headerCallbacks) Exit(name string) error {
+	return nil
+}
+
+// Forwards the callbacks of a statement parsed inside of a synthesized
+// graph, dropping those of the synthesized graph. When buffering the
+// callbacks are held until replay so that nothing is delivered for a
+// statement which fails to parse.
+type bodyCallbacks struct {
+	Callbacks
+	buffer bool
+	events []func() error
+}
+
+func (b *bodyCallbacks) Enter(name string, n *combos.Node) error {
+	if b.Callbacks == nil || name == "Graph" {
+		return nil
+	}
+	return b.deliver(func() error { return b.Callbacks.Enter(name, n) })
+}
+
+func (b *bodyCallbacks) Stmt(n *combos.Node) error {
+	if b.Callbacks == nil {
+		return nil
+	}
+	return b.deliver(func() error { return b.Callbacks.Stmt(n) })
+}
+
+func (b *bodyCallbacks) Exit(name string) error {
+	if b.Callbacks == nil || name == "Graph" {
+		return nil
+	}
+	return b.deliver(func() error { return b.Callbacks.Exit(name) })
+}
+
+func (b *bodyCallbacks) deliver(event func() error) error {
+	if b.buffer {
+		b.events = append(b.events, event)
+		return nil
+	}
+	return event()
+}
+
+// Deliver the buffered callbacks
+func (b *bodyCallbacks) replay() error {
+	for _, event := range b.events {
+		if err := event(); err != nil {
+			return err
+		}
+	}
+	b.events = nil
+	return nil
+}
+
+// A tokenReader lexes the input of an io.Reader incrementally. It holds a
+// window of the input from the first byte which has not been released up to
+// the last byte read.
+type tokenReader struct {
+	r       io.Reader
+	lexer   *lex.Lexer
+	adj     *adjuster
+	buf     []byte // the window, buf[0] is at offset base of the input
+	base    int
+	eof     bool
+	scanned int // the end of the last token lexed, relative to buf
+	line    int // the line of buf[scanned]
+	col     int // the column of buf[scanned]
+	pending []*lex.Token
+	lines   *LineMap // records the line markers lexed over when non nil
+	ids     *idSet   // records the IDs lexed, see DotParser.NextName
+}
+
+// The next token of the input or io.EOF
+func (t *tokenReader) next() (*lex.Token, error) {
+	for len(t.pending) == 0 {
+		if t.eof && t.scanned >= len(t.buf) {
+			return nil, io.EOF
+		}
+		if err := t.lex(); err != nil {
+			return nil, err
+		}
+	}
+	tok := t.pending[0]
+	t.pending = t.pending[1:]
+	return tok, nil
+}
+
+// The input text between two offsets. The text must not have been released.
+func (t *tokenReader) bytes(start, end int) []byte {
+	return t.buf[start-t.base : end-t.base]
+}
+
+// The input text before offset is no longer required.
+func (t *tokenReader) release(offset int) {
+	n := offset - t.base
+	if n <= 0 {
+		return
+	}
+	if n > t.scanned {
+		n = t.scanned
+	}
+	t.buf = t.buf[n:]
+	t.base += n
+	t.scanned -= n
+}
+
+// Lex the text after the last token lexed. Tokens which reach the end of the
+// window may continue past it so they are only accepted at the end of the
+// input, otherwise more input is read and the text is lexed again.
+func (t *tokenReader) lex() error {
+	text := t.buf[t.scanned:]
+	*t.adj = adjuster{tc: t.base + t.scanned, line: t.line, col: t.col}
+	s, err := t.lexer.Scanner(text)
+	if err != nil {
+		return err
+	}
+	end := 0
+	var lexErr error
+	for tok, err, eof := s.Next(); !eof; tok, err, eof = s.Next() {
+		if err != nil {
+			lexErr = err
+			break
+		}
+		token := tok.(*lex.Token)
+		tokEnd := tokenEnd(token) - t.adj.tc
+		if tokEnd >= len(text) && !t.eof {
+			break
+		}
+		t.pending = append(t.pending, token)
+		t.ids.add(token)
+		end = tokEnd
+	}
+	if len(t.pending) > 0 {
+		if t.lines != nil {
+			s := markerScan{m: t.lines, line: t.line, bol: t.col == 1}
+			s.tokens(text, t.adj.tc, t.pending)
+		}
+		t.advance(end)
+		return nil
+	}
+	if lexErr != nil && (t.eof || t.failedBefore(lexErr, len(text))) {
+		return t.adj.error(lexErr)
+	}
+	if t.eof {
+		// only whitespace remained
+		t.advance(len(text))
+		return nil
+	}
+	return t.read()
+}
+
+// Whether the lexer failed before the end of the text, in which case reading
+// more input will not help.
+func (t *tokenReader) failedBefore(err error, n int) bool {
+	switch e := err.(type) {
+	case *machines.UnconsumedInput:
+		return e.FailTC < n
+	case *lexError:
+		return !e.atEnd
+	}
+	return false
+}
+
+// Skip the input from the last token lexed to the end of the line holding
+// offset.
+func (t *tokenReader) skipLine(offset int) error {
+	t.pending = t.pending[:0]
+	for {
+		from := offset - t.base
+		if from < t.scanned {
+			from = t.scanned
+		} else if from > len(t.buf) {
+			from = len(t.buf)
+		}
+		if i := bytes.IndexByte(t.buf[from:], '\n'); i >= 0 {
+			t.advance(from + i + 1 - t.scanned)
+			return nil
+		}
+		if t.eof {
+			t.advance(len(t.buf) - t.scanned)
+			return nil
+		}
+		if err := t.read(); err != nil {
+			return err
+		}
+	}
+}
+
+// Move scanned forward by n bytes tracking the line and the column in runes.
+func (t *tokenReader) advance(n int) {
+	for _, b := range t.buf[t.scanned : t.scanned+n] {
+		if b == '\n' {
+			t.line++
+			t.col = 1
+		} else if utf8.RuneStart(b) {
+			t.col++
+		}
+	}
+	t.scanned += n
+}
+
+func (t *tokenReader) read() error {
+	if len(t.buf)+readSize > cap(t.buf) {
+		buf := make([]byte, len(t.buf), 2*len(t.buf)+readSize)
+		copy(buf, t.buf)
+		t.buf = buf
+	}
+	n, err := t.r.Read(t.buf[len(t.buf) : len(t.buf)+readSize])
+	t.buf = t.buf[:len(t.buf)+n]
+	if err == io.EOF {
+		t.eof = true
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing/iotest"
+)
+
+import (
+	. "github.com/timtadh/combos"
+	"github.com/timtadh/dot/ast"
+)
+
+type recordCallbacks struct {
+	events []string
+}
+
+func (r *recordCallbacks) Stmt(n *Node) error {
+	r.events = append(r.events, fmt.Sprintf("stmt %v", n.Label))
+	return nil
+}
+
+func (r *recordCallbacks) Enter(name string, n *Node) error {
+	r.events = append(r.events, fmt.Sprintf("enter %v", name))
+	return nil
+}
+
+func (r *recordCallbacks) Exit(name string) error {
+	r.events = append(r.events, fmt.Sprintf("exit %v", name))
+	return nil
+}
+
+const readerText = `// leading comment
+digraph "afp" {
+	node [style=filled fillcolor="#f8f8f8"]
+	subgraph cluster_L { L [shape=box fontsize=32 label="File: afp\lType: cpu\l"] }
+	N1 [label=<<b>runtime.cgocall</b>> fontsize=24 shape=box]
+	a b c; d
+	/* a block
+	   comment */
+	N507 -> N508 [label=" 24.64ms" weight=91]
+	{ x } -> y -> { z } [w=0.5]
+	subgraph { q }
+	rankdir=LR
+}
+strict graph { a -- b; b -- a }
+`
+
+func assertSameEvents(t *test.T, expected, got []string) {
+	t.Assert(len(expected) == len(got), "expected %v got %v", expected, got)
+	for i := range expected {
+		t.Assert(expected[i] == got[i], "event %d: expected %v got %v", i, expected[i], got[i])
+	}
+}
+
+func TestStreamParseReader(x *testing.T) {
+	t := (*test.T)(x)
+	expected := &recordCallbacks{}
+	t.AssertNil(StreamParse([]byte(readerText), expected))
+	got := &recordCallbacks{}
+	t.AssertNil(StreamParseReader(bytes.NewReader([]byte(readerText)), got))
+	assertSameEvents(t, expected.events, got.events)
+}
+
+func TestStreamParseReaderOneByte(x *testing.T) {
+	t := (*test.T)(x)
+	expected := &recordCallbacks{}
+	t.AssertNil(StreamParse([]byte(readerText), expected))
+	got := &recordCallbacks{}
+	r := iotest.OneByteReader(bytes.NewReader([]byte(readerText)))
+	t.AssertNil(StreamParseReader(r, got))
+	assertSameEvents(t, expected.events, got.events)
+}
+
+const joinedText = `digraph "a" + "b" {
+	subgraph "x" +
+		"y" { a } -> c
+	subgraph "p" + "q" + "r" { d }
+}
+strict graph "s" + "t" { e }
+`
+
+func TestStreamParseReaderJoinedIDs(x *testing.T) {
+	t := (*test.T)(x)
+	expected := &recordCallbacks{}
+	t.AssertNil(StreamParse([]byte(joinedText), expected))
+	got := &recordCallbacks{}
+	t.AssertNil(StreamParseReader(iotest.OneByteReader(bytes.NewReader([]byte(joinedText))), got))
+	assertSameEvents(t, expected.events, got.events)
+	names := &nameCallbacks{}
+	t.AssertNil(StreamParseReader(bytes.NewReader([]byte(joinedText)), names))
+	assertSameEvents(t, []string{"ab", "xy", "pqr", "st"}, names.names)
+}
+
+func TestStreamParseReaderLocations(x *testing.T) {
+	t := (*test.T)(x)
+	text := "digraph {\n  a -> b\n  c [x=y]\n}"
+	var stmts []*Node
+	call := &stmtCollector{stmts: &stmts}
+	t.AssertNil(StreamParseReader(iotest.OneByteReader(bytes.NewReader([]byte(text))), call))
+	t.Assert(len(stmts) == 2, "expected 2 stmts got %v", len(stmts))
+	l := stmts[1].Get(0).Location()
+	t.Assert(l.StartLine == 3 && l.StartColumn == 3, "expected 3:3 got %v:%v", l.StartLine, l.StartColumn)
+	t.Assert(l.StartTC == 21, "expected offset 21 got %v", l.StartTC)
+}
+
+func TestStreamParseReaderRuneColumns(x *testing.T) {
+	t := (*test.T)(x)
+	text := "digraph {\n  日本 -> b\n  é [x=y]; ü\n}"
+	var stmts []*Node
+	call := &stmtCollector{stmts: &stmts}
+	t.AssertNil(StreamParseReader(iotest.OneByteReader(bytes.NewReader([]byte(text))), call))
+	t.Assert(len(stmts) == 3, "expected 3 stmts got %v", len(stmts))
+	l := stmts[0].Get(1).Location()
+	t.Assert(l.StartLine == 2 && l.StartColumn == 9, "expected 2:9 got %v:%v", l.StartLine, l.StartColumn)
+	l = stmts[2].Get(0).Location()
+	t.Assert(l.StartLine == 3 && l.StartColumn == 12, "expected 3:12 got %v:%v", l.StartLine, l.StartColumn)
+}
+
+type stmtCollector struct {
+	stmts *[]*Node
+}
+
+func (s *stmtCollector) Stmt(n *Node) error {
+	*s.stmts = append(*s.stmts, n)
+	return nil
+}
+
+func (s *stmtCollector) Enter(name string, n *Node) error { return nil }
+func (s *stmtCollector) Exit(name string) error           { return nil }
+
+func TestStreamParseReaderErrors(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{
+		`digraph { a -> }`,
+		`digraph { a `,
+		`digraph { "unclosed }`,
+		`a -> b`,
+	} {
+		err := StreamParseReader(bytes.NewReader([]byte(text)), &recordCallbacks{})
+		t.Assert(err != nil, "expected an error for %q", text)
+		t.Log(err)
+	}
+}
+
+const recoverText = `digraph G {
+	a -> b;
+	c -> ;
+	d [color=red];
+	e -> 2x;
+	f -> g
+}
+graph H { a -- b }
+`
+
+func TestParseRecover(x *testing.T) {
+	t := (*test.T)(x)
+	var stmts []*Node
+	p := NewDotParser(&stmtCollector{stmts: &stmts})
+	tree, diags, err := p.ParseRecover([]byte(recoverText))
+	t.AssertNil(err)
+	t.Assert(len(diags) == 2, "expected 2 diagnostics got %v", diags)
+	for i, line := range []int{3, 5} {
+		t.Assert(diags[i].Severity == SeverityError, "expected an error got %v", diags[i])
+		t.Assert(diags[i].Location.StartLine == line, "expected line %v got %v", line, diags[i])
+	}
+	t.Assert(len(stmts) == 4, "expected the 4 good stmts to be delivered got %v", len(stmts))
+	f, err := BuildAST(tree)
+	t.AssertNil(err)
+	t.Assert(len(f.Decls) == 2, "expected 2 graphs got %v", len(f.Decls))
+	g := f.Decls[0].(*ast.Graph)
+	t.Assert(g.ID.Value == "G" && len(g.Stmts) == 3, "expected G with 3 stmts got %v %v", g.ID, len(g.Stmts))
+	h := f.Decls[1].(*ast.Graph)
+	t.Assert(h.ID.Value == "H" && len(h.Stmts) == 1, "expected H with 1 stmt got %v %v", h.ID, len(h.Stmts))
+}
+
+func TestParseRecoverGraphs(x *testing.T) {
+	t := (*test.T)(x)
+	for _, c := range []struct {
+		text  string
+		diags int
+		stmts []int
+	}{
+		{`digraph a b { x }`, 1, []int{1}},
+		{`digraph { a -> b`, 1, []int{1}},
+		{`digraph { x [a=b } graph { y }`, 1, []int{0, 1}},
+		{`junk digraph { x }`, 1, []int{1}},
+		{"digraph { a ; # b ; c\n d }", 1, []int{2}},
+		{`digraph { subgraph { a -> } b }`, 1, []int{2}},
+		{`digraph { subgraph s { a -> } -> b }`, 1, []int{0}},
+	} {
+		p := NewDotParser(nil)
+		tree, diags, err := p.ParseRecover([]byte(c.text))
+		t.AssertNil(err)
+		t.Assert(len(diags) == c.diags, "%v: expected %v diagnostics got %v", c.text, c.diags, diags)
+		f, err := BuildAST(tree)
+		t.AssertNil(err)
+		t.Assert(len(f.Decls) == len(c.stmts), "%v: expected %v graphs got %v", c.text, len(c.stmts), len(f.Decls))
+		for i, n := range c.stmts {
+			g := f.Decls[i].(*ast.Graph)
+			t.Assert(len(g.Stmts) == n, "%v: expected %v stmts got %v", c.text, n, len(g.Stmts))
+		}
+	}
+}
+
+const nestedRecoverText = `digraph {
+	subgraph cluster_a {
+		a -> ;
+		b;
+		subgraph cluster_b { c -> ; d }
+		e [color=];
+	}
+	f
+}`
+
+func TestParseRecoverNested(x *testing.T) {
+	t := (*test.T)(x)
+	got := &recordCallbacks{}
+	p := NewDotParser(got)
+	tree, diags, err := p.ParseRecover([]byte(nestedRecoverText))
+	t.AssertNil(err)
+	t.Assert(len(diags) == 3, "expected 3 diagnostics got %v", diags)
+	for i, line := range []int{3, 5, 6} {
+		t.Assert(diags[i].Location.StartLine == line, "expected line %v got %v", line, diags[i])
+	}
+	expected := []string{
+		"enter Graph",
+		"enter SubGraph", "stmt Node",
+		"enter SubGraph", "stmt Node", "exit SubGraph", "stmt SubGraph",
+		"exit SubGraph", "stmt SubGraph",
+		"stmt Node",
+		"exit Graph",
+	}
+	assertSameEvents(t, expected, got.events)
+
+	f, err := BuildAST(tree)
+	t.AssertNil(err)
+	g := f.Decls[0].(*ast.Graph)
+	t.Assert(len(g.Stmts) == 2, "expected 2 stmts got %v", len(g.Stmts))
+	a := g.Stmts[0].(*ast.Subgraph)
+	t.Assert(a.ID.Value == "cluster_a" && len(a.Stmts) == 2, "expected cluster_a with 2 stmts got %v %v", a.ID, len(a.Stmts))
+	b := a.Stmts[1].(*ast.Subgraph)
+	t.Assert(b.ID.Value == "cluster_b" && len(b.Stmts) == 1, "expected cluster_b with 1 stmt got %v %v", b.ID, len(b.Stmts))
+	spanTexts(t, tree, []byte(nestedRecoverText))
+
+	// the same events are delivered when reading
+	got = &recordCallbacks{}
+	p = NewDotParser(got)
+	p.Options.Recover = true
+	t.AssertNil(p.ParseReader(iotest.OneByteReader(bytes.NewReader([]byte(nestedRecoverText)))))
+	t.Assert(len(p.Diagnostics) == 3, "expected 3 diagnostics got %v", p.Diagnostics)
+	assertSameEvents(t, expected, got.events)
+}
+
+// A subgraph named by joined IDs is recovered as any other
+func TestParseRecoverJoinedSubgraph(x *testing.T) {
+	t := (*test.T)(x)
+	text := `digraph { subgraph "x" + "y" { a; b -> } c }`
+	tree, diags, err := NewDotParser(nil).ParseRecover([]byte(text))
+	t.AssertNil(err)
+	t.Assert(len(diags) == 1, "expected 1 diagnostic got %v", diags)
+	f, err := BuildAST(tree)
+	t.AssertNil(err)
+	g := f.Decls[0].(*ast.Graph)
+	t.Assert(len(g.Stmts) == 2, "expected 2 stmts got %v", len(g.Stmts))
+	sg := g.Stmts[0].(*ast.Subgraph)
+	t.Assert(sg.ID.Value == "xy" && len(sg.Stmts) == 1, "expected xy with 1 stmt got %v %v", sg.ID, len(sg.Stmts))
+}
+
+type failingCallbacks struct {
+	recordCallbacks
+}
+
+func (f *failingCallbacks) Stmt(n *Node) error {
+	return fmt.Errorf("stop")
+}
+
+func TestParseRecoverCallbackError(x *testing.T) {
+	t := (*test.T)(x)
+	p := NewDotParser(&failingCallbacks{})
+	_, _, err := p.ParseRecover([]byte(`digraph { a -> ; b; c }`))
+	t.Assert(err != nil, "expected the callback error")
+}
+
+func TestStreamParseReaderRecover(x *testing.T) {
+	t := (*test.T)(x)
+	got := &recordCallbacks{}
+	p := NewDotParser(got)
+	p.Options.Recover = true
+	t.AssertNil(p.ParseReader(iotest.OneByteReader(bytes.NewReader([]byte(recoverText)))))
+	t.Assert(len(p.Diagnostics) == 2, "expected 2 diagnostics got %v", p.Diagnostics)
+	expected := []string{
+		"enter Graph", "stmt Edge", "stmt Node", "stmt Edge", "exit Graph",
+		"enter Graph", "stmt Edge", "exit Graph",
+	}
+	assertSameEvents(t, expected, got.events)
+}
+
+// A reader of a digraph of n edges generated as it is read
+type edgesReader struct {
+	n    int
+	buf  []byte
+	done bool
+}
+
+func (r *edgesReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		switch {
+		case r.done:
+			return 0, io.EOF
+		case r.buf == nil:
+			r.buf = []byte("digraph {\n")
+		case r.n > 0:
+			r.buf = []byte(fmt.Sprintf("\ta -> b [label=%q];\n", strings.Repeat("x", 64)))
+			r.n--
+		default:
+			r.buf = []byte("}\n")
+			r.done = true
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Samples the heap in use as the statements are delivered
+type heapCallbacks struct {
+	stmts int
+	max   uint64
+}
+
+func (h *heapCallbacks) Stmt(n *Node) error {
+	h.stmts++
+	if h.stmts%10000 == 0 {
+		if heap := heapInUse(); heap > h.max {
+			h.max = heap
+		}
+	}
+	return nil
+}
+
+func (h *heapCallbacks) Enter(name string, n *Node) error { return nil }
+func (h *heapCallbacks) Exit(name string) error           { return nil }
+
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func TestStreamParseReaderBoundedMemory(x *testing.T) {
+	t := (*test.T)(x)
+	if testing.Short() {
+		x.Skip("parses a large graph")
+	}
+	const edges = 100000 // about 9MB of input
+	call := &heapCallbacks{}
+	base := heapInUse()
+	t.AssertNil(StreamParseReader(&edgesReader{n: edges}, call))
+	t.Assert(call.stmts == edges, "expected %v statements got %v", edges, call.stmts)
+	growth := int64(call.max) - int64(base)
+	t.Assert(growth < 2<<20, "expected the heap to stay within 2MB of its start grew by %v bytes", growth)
+}
@@ -0,0 +1,106 @@
+package dot
+
+import (
+	"github.com/timtadh/combos"
+)
+
+// The join of the locations of nodes, those without one are skipped. The ID
+// of a node with a port has the location of the ID token alone so its Port
+// is joined as well.
+func extent(nodes ...*combos.Node) *combos.Location {
+	var l *combos.Location
+	for _, n := range nodes {
+		if n == nil {
+			continue
+		}
+		l = join(l, n.Location())
+		if n.Label == "ID" {
+			for _, port := range n.Children {
+				l = join(l, port.Location())
+			}
+		}
+	}
+	return l
+}
+
+// The join of two locations either of which may be nil. Neither is changed.
+func join(a, b *combos.Location) *combos.Location {
+	if a == nil {
+		return b
+	} else if b == nil {
+		return a
+	}
+	c := *a
+	return c.Join(b)
+}
+
+// A zero width location at the start of l, for a node synthesized by the
+// parser which comes before l.
+func startOf(l *combos.Location) *combos.Location {
+	return &combos.Location{
+		StartTC:     l.StartTC,
+		EndTC:       l.StartTC,
+		StartLine:   l.StartLine,
+		StartColumn: l.StartColumn,
+		EndLine:     l.StartLine,
+		EndColumn:   l.StartColumn,
+	}
+}
+
+// A zero width location following l
+func endOf(l *combos.Location) *combos.Location {
+	return &combos.Location{
+		StartTC:     l.EndTC,
+		EndTC:       l.EndTC,
+		StartLine:   l.EndLine,
+		StartColumn: l.EndColumn + 1,
+		EndLine:     l.EndLine,
+		EndColumn:   l.EndColumn + 1,
+	}
+}
+
+// Locate n at extent of nodes. When n is empty and none of the nodes has a
+// location n is given a zero width location following after, when after
+// has one.
+func locate(n *combos.Node, after *combos.Node, nodes ...*combos.Node) *combos.Node {
+	if l := extent(nodes...); l != nil {
+		return n.SetLocation(l)
+	}
+	if after != nil {
+		if l := extent(after); l != nil {
+			return n.SetLocation(endOf(l))
+		}
+	}
+	return n
+}
+
+// Locate the root of a parse at its graphs, an empty input is a zero width
+// location at its start.
+func locateRoot(n *combos.Node) *combos.Node {
+	if extent(n.Children...) == nil {
+		return n.SetLocation(&combos.Location{
+			StartLine:   1,
+			StartColumn: 1,
+			EndLine:     1,
+			EndColumn:   1,
+		})
+	}
+	return locate(n, nil, n.Children...)
+}
+
+// Parse text whose index i is at offset i-shift of the input
+func (d *DotParser) setText(text []byte, shift int) {
+	d.text, d.shift = text, shift
+}
+
+// The text of the input at l, false when it is not known
+func (d *DotParser) source(l *combos.Location) (string, bool) {
+	if l == nil || d.text == nil {
+		return "", false
+	}
+	i, j := l.StartTC+d.shift, l.EndTC+d.shift
+	if i < 0 || j > len(d.text) || i > j {
+		return "", false
+	}
+	return string(d.text[i:j]), true
+}
@@ -0,0 +1,118 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"fmt"
+)
+
+import (
+	. "github.com/timtadh/combos"
+)
+
+// The label of each node of n, in pre-order, with the text its location
+// spans
+func spanTexts(t *test.T, n *Node, text []byte) []string {
+	l := n.Location()
+	t.Assert(l != nil, "expected %v to have a location", n)
+	t.Assert(0 <= l.StartTC && l.StartTC <= l.EndTC && l.EndTC <= len(text),
+		"expected %v to be within the text got %v-%v", n, l.StartTC, l.EndTC)
+	spans := []string{fmt.Sprintf("%v %q", n.Label, text[l.StartTC:l.EndTC])}
+	for _, kid := range n.Children {
+		spans = append(spans, spanTexts(t, kid, text)...)
+	}
+	return spans
+}
+
+func TestSpans(x *testing.T) {
+	t := (*test.T)(x)
+	text := []byte(`strict digraph g {
+	node [shape=box]
+	a:p:n -> b -> {c} [w=1]
+	subgraph {d}
+	e
+	x="y" + "z"
+}`)
+	body := string(text[len("strict digraph g "):])
+	expected := []string{
+		fmt.Sprintf("Graphs %q", text),
+		fmt.Sprintf("Graph %q", text),
+		`DIGRAPH "digraph"`,
+		`STRICT "strict"`,
+		`ID "g"`,
+		fmt.Sprintf("Stmts %q", body),
+		`NodeAttrs "node [shape=box]"`,
+		`Attr "shape=box"`,
+		`ID "shape"`,
+		`ID "box"`,
+		`Edge "a:p:n -> b"`,
+		`ID "a"`,
+		`Port ":p:n"`,
+		`ID "p"`,
+		`ID "n"`,
+		`ID "b"`,
+		`Attrs "[w=1]"`,
+		`Attr "w=1"`,
+		`ID "w"`,
+		`ID "1"`,
+		`Edge "b -> {c} [w=1]"`,
+		`ID "b"`,
+		`SubGraph "{c}"`,
+		`ID ""`,
+		`Stmts "{c}"`,
+		`Node "c"`,
+		`ID "c"`,
+		`Attrs ""`,
+		`Attrs "[w=1]"`,
+		`Attr "w=1"`,
+		`ID "w"`,
+		`ID "1"`,
+		`SubGraph "subgraph {d}"`,
+		`ID ""`,
+		`Stmts "{d}"`,
+		`Node "d"`,
+		`ID "d"`,
+		`Attrs ""`,
+		`Node "e"`,
+		`ID "e"`,
+		`Attrs ""`,
+		`Attr "x=\"y\" + \"z\""`,
+		`ID "x"`,
+		`ID "\"y\" + \"z\""`,
+	}
+	n, err := Parse(text)
+	t.AssertNil(err)
+	got := spanTexts(t, n, text)
+	t.Assert(fmt.Sprint(got) == fmt.Sprint(expected), "expected %q got %q", expected, got)
+
+	// the statements parsed on their own are placed in the same text
+	n, diags, err := NewDotParser(nil).ParseRecover(text)
+	t.AssertNil(err)
+	t.Assert(len(diags) == 0, "expected no diagnostics got %v", diags)
+	got = spanTexts(t, n, text)
+	t.Assert(fmt.Sprint(got) == fmt.Sprint(expected), "expected %q got %q", expected, got)
+
+	// synthesized nodes are zero width where they would be written
+	sg := n.Get(0).Get(2).Get(3).Get(0)
+	l := sg.Location()
+	at := len("strict digraph g {\n\tnode [shape=box]\n\ta:p:n -> b -> {c} [w=1]\n\tsubgraph")
+	t.Assert(l.StartTC == at, "expected offset %v got %v", at, l.StartTC)
+	t.Assert(l.StartLine == 4 && l.StartColumn == 10, "expected 4:10 got %v:%v", l.StartLine, l.StartColumn)
+}
+
+func TestSpansAnonymousGraph(x *testing.T) {
+	t := (*test.T)(x)
+	for _, text := range []string{`graph {}`, `strict graph {}`} {
+		n, err := Parse([]byte(text))
+		t.AssertNil(err)
+		spanTexts(t, n, []byte(text))
+		l := n.Get(0).Get(1).Location()
+		at := len(text) - len(" {}")
+		t.Assert(l.StartTC == at && l.EndTC == at, "%v: expected offset %v got %v-%v", text, at, l.StartTC, l.EndTC)
+	}
+	n, err := Parse([]byte(""))
+	t.AssertNil(err)
+	l := n.Location()
+	t.Assert(l.StartTC == 0 && l.EndTC == 0, "expected an empty span got %v-%v", l.StartTC, l.EndTC)
+}
@@ -0,0 +1,179 @@
+package dot
+
+import (
+	"github.com/timtadh/combos"
+)
+
+// Callbacks which also want the attributes of the duplicate edges of strict
+// graphs collapsed by StrictCallbacks. MergeEdge is called with the Edge
+// statement of each duplicate, its attributes are to be merged into those of
+// the first edge between the same nodes.
+type EdgeMerger interface {
+	MergeEdge(n *combos.Node) error
+}
+
+// Wrap call so that within strict graphs the duplicate edges between two
+// nodes are not delivered to Stmt. With StrictReport a duplicate is dropped
+// and recorded in the Diagnostics of the parser, otherwise it is passed to
+// MergeEdge when call is an EdgeMerger. Callbacks which cannot merge an
+// edge are delivered the duplicate to Stmt, so no attribute is lost.
+//
+// An edge statement with a subgraph endpoint stands for an edge from each
+// node of one endpoint to each node of the other. In a strict graph it is
+// delivered as those edges, each an Edge between two nodes sharing the
+// attributes of the statement, and the duplicates among them are collapsed
+// like any other.
+//
+// The parsers wrap their Callbacks in this way when Options.Strict is
+// StrictMerge or StrictReport. With the default, StrictKeep, every edge is
+// delivered as it is written unless the Callbacks are wrapped here.
+func (d *DotParser) StrictCallbacks(call Callbacks) Callbacks {
+	merger, _ := call.(EdgeMerger)
+	return &strictCallbacks{Callbacks: events(call), d: d, merger: merger}
+}
+
+// The Callbacks delivering to call with the duplicate edges of strict graphs
+// collapsed as Options.Strict says
+func (d *DotParser) strictCallbacks(call Callbacks) Callbacks {
+	if _, ok := call.(*strictCallbacks); ok || d.Options.Strict == StrictKeep {
+		return events(call)
+	}
+	return d.StrictCallbacks(call)
+}
+
+type strictCallbacks struct {
+	Callbacks
+	d        *DotParser
+	merger   EdgeMerger // call when it is one
+	strict   bool
+	directed bool
+	name     string
+	edges    map[[2]string]bool
+	// the subgraphs entered but not exited, innermost last, and the nodes
+	// of those exited by their SubGraph node
+	open    []*members
+	members map[*combos.Node][]*combos.Node
+}
+
+// The nodes of a subgraph
+type members struct {
+	subgraph *combos.Node
+	ids      []*combos.Node // an ID node for each, without ports
+	has      map[string]bool
+}
+
+func (s *strictCallbacks) Enter(name string, n *combos.Node) error {
+	switch name {
+	case "Graph":
+		graphType := n.Get(0)
+		s.strict = len(graphType.Children) > 0 && graphType.Get(0).Label == "STRICT"
+		s.directed = graphType.Label == "DIGRAPH"
+		s.name = idValue(n.Get(1))
+		s.edges = make(map[[2]string]bool)
+		s.open = nil
+		s.members = make(map[*combos.Node][]*combos.Node)
+	case "SubGraph":
+		if s.strict {
+			s.open = append(s.open, &members{subgraph: n, has: make(map[string]bool)})
+		}
+	}
+	return s.Callbacks.Enter(name, n)
+}
+
+func (s *strictCallbacks) Exit(name string) error {
+	if name == "SubGraph" && s.strict && len(s.open) > 0 {
+		m := s.open[len(s.open)-1]
+		s.open = s.open[:len(s.open)-1]
+		s.members[m.subgraph] = m.ids
+		// the nodes of a subgraph belong to its parent as well
+		s.member(m.ids...)
+	}
+	return s.Callbacks.Exit(name)
+}
+
+// Record ids as nodes of the subgraph being parsed
+func (s *strictCallbacks) member(ids ...*combos.Node) {
+	if len(s.open) == 0 {
+		return
+	}
+	m := s.open[len(s.open)-1]
+	for _, id := range ids {
+		if v := idValue(id); !m.has[v] {
+			m.has[v] = true
+			if len(id.Children) > 0 {
+				bare := combos.NewValueNode("ID", id.Value)
+				bare.SetLocation(id.Location())
+				id = bare
+			}
+			m.ids = append(m.ids, id)
+		}
+	}
+}
+
+func (s *strictCallbacks) Stmt(n *combos.Node) error {
+	if !s.strict {
+		return s.Callbacks.Stmt(n)
+	}
+	switch n.Label {
+	case "Node":
+		s.member(n.Get(0))
+	case "SubGraph":
+		// only the subgraphs of edges are looked up
+		delete(s.members, n)
+	}
+	if n.Label != "Edge" {
+		return s.Callbacks.Stmt(n)
+	}
+	from, to := s.vertices(n.Get(0)), s.vertices(n.Get(1))
+	s.member(from...)
+	s.member(to...)
+	if n.Get(0).Label == "ID" && n.Get(1).Label == "ID" {
+		return s.edge(n)
+	}
+	for _, f := range from {
+		for _, t := range to {
+			e := combos.NewNode("Edge").AddKid(f).AddKid(t).AddKid(n.Get(2))
+			e.SetLocation(n.Location())
+			if err := s.edge(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The nodes of an edge endpoint
+func (s *strictCallbacks) vertices(v *combos.Node) []*combos.Node {
+	if v.Label == "SubGraph" {
+		return s.members[v]
+	}
+	return []*combos.Node{v}
+}
+
+// Deliver the Edge n between two nodes unless it duplicates an earlier edge
+func (s *strictCallbacks) edge(n *combos.Node) error {
+	from, to := idValue(n.Get(0)), idValue(n.Get(1))
+	key := [2]string{from, to}
+	if !s.directed && from > to {
+		key = [2]string{to, from}
+	}
+	if !s.edges[key] {
+		s.edges[key] = true
+		return s.Callbacks.Stmt(n)
+	}
+	switch s.d.Options.Strict {
+	case StrictReport:
+		op := "--"
+		if s.directed {
+			op = "->"
+		}
+		s.d.diagnose(SeverityWarning, n.Location(),
+			"duplicate edge %v %v %v in strict graph %v", quoteID(from), op, quoteID(to), s.name)
+		return nil
+	default:
+		if s.merger != nil {
+			return s.merger.MergeEdge(n)
+		}
+		return s.Callbacks.Stmt(n)
+	}
+}
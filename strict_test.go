@@ -0,0 +1,117 @@
+package dot
+
+import "testing"
+import "github.com/timtadh/data-structures/test"
+
+import (
+	"strings"
+)
+
+import (
+	. "github.com/timtadh/combos"
+)
+
+type mergeCollector struct {
+	stmtCollector
+	merged []*Node
+}
+
+func (m *mergeCollector) MergeEdge(n *Node) error {
+	m.merged = append(m.merged, n)
+	return nil
+}
+
+func TestStrictCallbacks(x *testing.T) {
+	t := (*test.T)(x)
+	var stmts []*Node
+	call := &mergeCollector{stmtCollector: stmtCollector{stmts: &stmts}}
+	p := NewDotParser(nil)
+	p.Callbacks = p.StrictCallbacks(call)
+	_, err := p.Parse([]byte(`strict graph { a -- b; b -- a [w=2]; a -- {b}; c } graph { a -- b; a -- b }`))
+	t.AssertNil(err)
+	// the b inside of the subgraph is a statement of its own, the edge to
+	// the subgraph duplicates a -- b
+	t.Assert(len(stmts) == 5, "expected 5 stmts got %v", len(stmts))
+	t.Assert(len(call.merged) == 2, "expected 2 merged edges got %v", len(call.merged))
+}
+
+// The endpoints of the Edge statements delivered
+func edgeNames(stmts []*Node) []string {
+	var edges []string
+	for _, n := range stmts {
+		if n.Label == "Edge" {
+			edges = append(edges, idValue(n.Get(0))+"-"+idValue(n.Get(1)))
+		}
+	}
+	return edges
+}
+
+const strictSubgraphText = `strict digraph {
+	a -> b
+	{a b} -> c [w=1]
+	subgraph s { c; subgraph { d } } -> a
+	a -> {b c e}
+}`
+
+func TestStrictSubgraphEndpoints(x *testing.T) {
+	t := (*test.T)(x)
+	expected := []string{"a-b", "a-c", "b-c", "c-a", "d-a", "a-e"}
+	var stmts []*Node
+	call := &mergeCollector{stmtCollector: stmtCollector{stmts: &stmts}}
+	p := NewDotParser(call)
+	p.Options.Strict = StrictMerge
+	_, err := p.Parse([]byte(strictSubgraphText))
+	t.AssertNil(err)
+	assertSameEvents(t, expected, edgeNames(stmts))
+	t.Assert(len(call.merged) == 2, "expected 2 merged edges got %v", len(call.merged))
+	for _, n := range stmts {
+		if n.Label == "Edge" && idValue(n.Get(0)) == "a" && idValue(n.Get(1)) == "c" {
+			attrs := n.Get(2)
+			t.Assert(len(attrs.Children) == 1, "expected the attributes of the statement got %v", attrs)
+		}
+	}
+
+	stmts = nil
+	call = &mergeCollector{stmtCollector: stmtCollector{stmts: &stmts}}
+	p = NewDotParser(call)
+	p.Options.Strict = StrictMerge
+	t.AssertNil(p.ParseReader(strings.NewReader(strictSubgraphText)))
+	assertSameEvents(t, expected, edgeNames(stmts))
+	t.Assert(len(call.merged) == 2, "expected 2 merged edges got %v", len(call.merged))
+}
+
+func TestStrictKeep(x *testing.T) {
+	t := (*test.T)(x)
+	var stmts []*Node
+	// the parsers deliver every edge by default
+	p := NewDotParser(&stmtCollector{stmts: &stmts})
+	_, err := p.Parse([]byte(`strict graph { a -- b; b -- a; a -- {b} }`))
+	t.AssertNil(err)
+	t.Assert(len(stmts) == 4, "expected every stmt got %v", len(stmts))
+	t.Assert(len(p.Diagnostics) == 0, "expected no diagnostics got %v", p.Diagnostics)
+}
+
+// Callbacks which cannot merge an edge are delivered the duplicates
+func TestStrictMergeNoMerger(x *testing.T) {
+	t := (*test.T)(x)
+	var stmts []*Node
+	p := NewDotParser(&stmtCollector{stmts: &stmts})
+	p.Options.Strict = StrictMerge
+	_, err := p.Parse([]byte(`strict graph { a -- b; b -- a [w=2]; a -- {b c} }`))
+	t.AssertNil(err)
+	assertSameEvents(t, []string{"a-b", "b-a", "a-b", "a-c"}, edgeNames(stmts))
+	t.Assert(len(stmts[1].Get(2).Children) == 1, "expected the attributes of the duplicate got %v", stmts[1])
+	t.Assert(len(p.Diagnostics) == 0, "expected no diagnostics got %v", p.Diagnostics)
+}
+
+func TestStrictCallbacksReport(x *testing.T) {
+	t := (*test.T)(x)
+	var stmts []*Node
+	p := NewDotParser(nil)
+	p.Options.Strict = StrictReport
+	p.Callbacks = p.StrictCallbacks(&stmtCollector{stmts: &stmts})
+	_, err := p.Parse([]byte(`strict digraph { a -> b; b -> a; a -> b }`))
+	t.AssertNil(err)
+	t.Assert(len(stmts) == 2, "expected 2 stmts got %v", len(stmts))
+	t.Assert(len(p.Diagnostics) == 1, "expected 1 diagnostic got %v", p.Diagnostics)
+}